@@ -0,0 +1,409 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// nameSuffixes are the parts of a name which stay at the end of its sort name, as in "King, Martin Luther, Jr.".
+var nameSuffixes = map[string]bool{"jr": true, "jr.": true, "sr": true, "sr.": true, "ii": true, "iii": true, "iv": true, "phd": true, "ph.d.": true}
+
+// SortName returns the name an author is sorted by, with the last name first, as in "King, Stephen".
+// Names which already contain a comma, or are a single word, are returned unchanged.
+func SortName(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.Contains(name, ",") {
+		return name
+	}
+	words := strings.Fields(name)
+	suffix := ""
+	if len(words) > 2 && nameSuffixes[strings.ToLower(words[len(words)-1])] {
+		suffix = ", " + words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	if len(words) < 2 {
+		return name
+	}
+	return words[len(words)-1] + ", " + strings.Join(words[:len(words)-1], " ") + suffix
+}
+
+// An AuthorAlias maps a pen name or variant spelling to the author it belongs to.
+type AuthorAlias struct {
+	Alias  string `json:"alias"`
+	Author string `json:"author"`
+}
+
+// resolveAuthor finds the author a name refers to, and returns its ID and name.
+// The name matches an author with that exact name, then an alias, ignoring case,
+// then an author with the same sort name, so that "King, Stephen" finds Stephen King.
+func resolveAuthor(tx *sql.Tx, name string) (int64, string, bool, error) {
+	var id int64
+	var canonical string
+	queries := []struct {
+		query string
+		arg   string
+	}{
+		{"select id, name from authors where name=?", name},
+		{"select a.id, a.name from author_aliases al join authors a on a.id = al.author_id where al.alias=? collate nocase", name},
+		{"select id, name from authors where sort_name=? collate nocase order by id limit 1", SortName(name)},
+	}
+	for _, q := range queries {
+		err := tx.QueryRow(q.query, q.arg).Scan(&id, &canonical)
+		if err == nil {
+			return id, canonical, true, nil
+		} else if err != sql.ErrNoRows {
+			return 0, "", false, errors.Wrap(err, "resolve author")
+		}
+	}
+	return 0, "", false, nil
+}
+
+// resolveAuthorNames replaces each name with the name of the author it refers to, as described in resolveAuthor.
+// Names which don't refer to an author are kept.
+func resolveAuthorNames(tx *sql.Tx, names []string) ([]string, error) {
+	resolved := make([]string, len(names))
+	for i, name := range names {
+		_, canonical, found, err := resolveAuthor(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = name
+		if found {
+			resolved[i] = canonical
+		}
+	}
+	return resolved, nil
+}
+
+// getOrInsertAuthor returns the ID of the author a name refers to, adding a new author if there isn't one.
+func getOrInsertAuthor(tx *sql.Tx, name string) (int64, error) {
+	id, _, found, err := resolveAuthor(tx, name)
+	if err != nil || found {
+		return id, err
+	}
+	res, err := tx.Exec("insert into authors (name, sort_name) values (?, ?)", name, SortName(name))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// SetAuthorSortName changes the sort name of an author.
+// If sortName is empty, the sort name is derived from the author's name, as described in SortName.
+func (lib *Library) SetAuthorSortName(author, sortName string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "set sort name")
+	}
+	defer tx.Rollback()
+
+	id, name, found, err := resolveAuthor(tx, author)
+	if err != nil {
+		return errors.Wrap(err, "set sort name")
+	}
+	if !found {
+		return errors.Errorf("author %s not found", author)
+	}
+	if sortName = strings.TrimSpace(sortName); sortName == "" {
+		sortName = SortName(name)
+	}
+	if _, err := tx.Exec("update authors set updated_on=datetime(), sort_name=? where id=?", sortName, id); err != nil {
+		return errors.Wrap(err, "set sort name")
+	}
+	return errors.Wrap(tx.Commit(), "set sort name")
+}
+
+// GetAuthorSortName returns the name and sort name of the author a name refers to, as described in resolveAuthor.
+func (lib *Library) GetAuthorSortName(author string) (string, string, bool, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return "", "", false, errors.Wrap(err, "get sort name")
+	}
+	defer tx.Rollback()
+
+	id, name, found, err := resolveAuthor(tx, author)
+	if err != nil || !found {
+		return "", "", false, err
+	}
+	var sortName string
+	if err := tx.QueryRow("select sort_name from authors where id=?", id).Scan(&sortName); err != nil {
+		return "", "", false, errors.Wrap(err, "get sort name")
+	}
+	return name, sortName, true, nil
+}
+
+// AddAuthorAlias makes alias another name for author, so books imported or edited with the alias are linked to author.
+// The author is added if it doesn't exist yet.
+// If there is already an author named alias, its books are moved to author, and it is removed;
+// books which end up with the same title and authors as another book are merged into it.
+// It returns the IDs of the moved books, whose file names may need to change.
+func (lib *Library) AddAuthorAlias(alias, author string) ([]int64, error) {
+	alias, author = strings.TrimSpace(alias), strings.TrimSpace(author)
+	if alias == "" || author == "" {
+		return nil, errors.New("the alias and author must not be empty")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "add alias")
+	}
+	defer tx.Rollback()
+
+	authorID, err := getOrInsertAuthor(tx, author)
+	if err != nil {
+		return nil, errors.Wrap(err, "add alias")
+	}
+	var aliasID int64
+	err = tx.QueryRow("select id from authors where name=?", alias).Scan(&aliasID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "add alias")
+	}
+	if aliasID == authorID {
+		return nil, errors.Errorf("%s is already the name of that author", alias)
+	}
+	var bookIDs []int64
+	if aliasID != 0 {
+		if bookIDs, err = moveAuthor(tx, aliasID, authorID); err != nil {
+			return nil, errors.Wrapf(err, "move books from %s", alias)
+		}
+		if bookIDs, err = mergeDuplicateBooks(tx, bookIDs); err != nil {
+			return nil, errors.Wrap(err, "merge duplicate books")
+		}
+	}
+	if _, err := tx.Exec("insert or replace into author_aliases (alias, author_id) values (?, ?)", alias, authorID); err != nil {
+		return nil, errors.Wrap(err, "add alias")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "add alias")
+	}
+	return bookIDs, nil
+}
+
+// moveAuthor links the books of the author from to the author to, moves its aliases, and removes it.
+// The search index is updated for the moved books, and their IDs are returned.
+func moveAuthor(tx *sql.Tx, from, to int64) ([]int64, error) {
+	bookIDs, err := queryIDs(tx, "select book_id from books_authors where author_id=? order by book_id", from)
+	if err != nil {
+		return nil, err
+	}
+	// A book which already has both authors keeps its existing link to the new one.
+	queries := []string{
+		"update or ignore books_authors set updated_on=datetime(), author_id=? where author_id=?",
+		"update or ignore author_aliases set updated_on=datetime(), author_id=? where author_id=?",
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q, to, from); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("delete from books_authors where author_id=?", from); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("delete from author_aliases where author_id=?", from); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("delete from authors where id=?", from); err != nil {
+		return nil, err
+	}
+	for _, id := range bookIDs {
+		if err := reindexBookInSearch(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return bookIDs, nil
+}
+
+// mergeDuplicateBooks merges each of the given books with the other books that have the same title and authors,
+// for books which became duplicates when their authors changed. Books are merged into the one with the lowest ID.
+// It returns the IDs of the books which are left, in order.
+func mergeDuplicateBooks(tx *sql.Tx, ids []int64) ([]int64, error) {
+	bks, err := getBooksByID(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	left := make(map[int64]bool)
+	for _, book := range bks {
+		matches, err := getBookIDsByTitleAndAuthors(tx, book.Title, book.Authors)
+		if err != nil {
+			return nil, err
+		}
+		if !containsInt64(matches, book.ID) {
+			// The book was already merged into another one.
+			continue
+		}
+		if len(matches) > 1 {
+			if err := mergeBooks(tx, matches); err != nil {
+				return nil, errors.Wrapf(err, "merge books %s", joinInt64s(matches, ", "))
+			}
+		}
+		left[matches[0]] = true
+	}
+	var leftIDs []int64
+	for id := range left {
+		leftIDs = append(leftIDs, id)
+	}
+	sort.Slice(leftIDs, func(i, j int) bool { return leftIDs[i] < leftIDs[j] })
+	return leftIDs, nil
+}
+
+// containsInt64 returns true if ids contains id.
+func containsInt64(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RenameAuthor changes the name of an author, and returns the IDs of the author's books, whose file names may need to change.
+// The sort name is derived from the new name, unless it was set to something else.
+// It fails if the new name already refers to another author; use MergeAuthors to combine them.
+func (lib *Library) RenameAuthor(oldName, newName string) ([]int64, error) {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return nil, errors.New("the new name must not be empty")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "rename author")
+	}
+	defer tx.Rollback()
+
+	id, name, found, err := resolveAuthor(tx, oldName)
+	if err != nil {
+		return nil, errors.Wrap(err, "rename author")
+	}
+	if !found {
+		return nil, errors.Errorf("author %s not found", oldName)
+	}
+	existingID, existing, found, err := resolveAuthor(tx, newName)
+	if err != nil {
+		return nil, errors.Wrap(err, "rename author")
+	}
+	if found && existingID != id {
+		return nil, errors.Errorf("%s already refers to the author %s", newName, existing)
+	}
+	_, err = tx.Exec(`update authors set updated_on=datetime(), name=?,
+	sort_name=case when sort_name=? then ? else sort_name end where id=?`, newName, SortName(name), SortName(newName), id)
+	if err != nil {
+		return nil, errors.Wrap(err, "rename author")
+	}
+	// An alias which is now the author's name is no longer needed.
+	if _, err := tx.Exec("delete from author_aliases where alias=? collate nocase", newName); err != nil {
+		return nil, errors.Wrap(err, "rename author")
+	}
+	bookIDs, err := queryIDs(tx, "select book_id from books_authors where author_id=? order by book_id", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "rename author")
+	}
+	for _, bookID := range bookIDs {
+		if err := reindexBookInSearch(tx, bookID); err != nil {
+			return nil, errors.Wrap(err, "index book in search")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "rename author")
+	}
+	return bookIDs, nil
+}
+
+// MergeAuthors moves the books of the given authors to the author into, and removes them.
+// Their aliases are moved as well. The author into is added if it doesn't exist yet.
+// Books which end up with the same title and authors as another book are merged into it.
+// It returns the IDs of the books which were moved, and are left after merging, in order.
+func (lib *Library) MergeAuthors(authors []string, into string) ([]int64, error) {
+	into = strings.TrimSpace(into)
+	if into == "" {
+		return nil, errors.New("the author to merge into must not be empty")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "merge authors")
+	}
+	defer tx.Rollback()
+
+	intoID, err := getOrInsertAuthor(tx, into)
+	if err != nil {
+		return nil, errors.Wrap(err, "merge authors")
+	}
+	var moved []int64
+	for _, author := range authors {
+		id, _, found, err := resolveAuthor(tx, author)
+		if err != nil {
+			return nil, errors.Wrap(err, "merge authors")
+		}
+		if !found {
+			return nil, errors.Errorf("author %s not found", author)
+		}
+		if id == intoID {
+			continue
+		}
+		bookIDs, err := moveAuthor(tx, id, intoID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "merge author %s", author)
+		}
+		moved = append(moved, bookIDs...)
+	}
+	bookIDs, err := mergeDuplicateBooks(tx, moved)
+	if err != nil {
+		return nil, errors.Wrap(err, "merge duplicate books")
+	}
+	// If nothing was merged into a new author, it isn't kept.
+	if err := deleteOrphans(tx); err != nil {
+		return nil, errors.Wrap(err, "delete orphans")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "merge authors")
+	}
+	return bookIDs, nil
+}
+
+// RemoveAuthorAlias removes an alias. Books already linked through it keep their author.
+func (lib *Library) RemoveAuthorAlias(alias string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "remove alias")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("delete from author_aliases where alias=? collate nocase", strings.TrimSpace(alias))
+	if err != nil {
+		return errors.Wrap(err, "remove alias")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "remove alias")
+	} else if n == 0 {
+		return errors.Errorf("alias %s not found", alias)
+	}
+	if err := deleteOrphans(tx); err != nil {
+		return errors.Wrap(err, "remove alias")
+	}
+	return errors.Wrap(tx.Commit(), "remove alias")
+}
+
+// GetAuthorAliases returns every alias, ordered by the sort name of its author and then by alias.
+func (lib *Library) GetAuthorAliases() ([]AuthorAlias, error) {
+	rows, err := lib.Query(`select al.alias, a.name from author_aliases al join authors a on a.id = al.author_id
+	order by a.sort_name collate nocase, al.alias collate nocase`)
+	if err != nil {
+		return nil, errors.Wrap(err, "get aliases")
+	}
+	defer rows.Close()
+
+	aliases := []AuthorAlias{}
+	for rows.Next() {
+		var a AuthorAlias
+		if err := rows.Scan(&a.Alias, &a.Author); err != nil {
+			return nil, errors.Wrap(err, "get aliases")
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, errors.Wrap(rows.Err(), "get aliases")
+}
@@ -22,32 +22,57 @@ import (
 
 // Book represents a book in a library.
 type Book struct {
-	ID      int64
-	Authors []string
-	Title   string
-	Series  string
-	Files   []BookFile
+	ID      int64    `json:"id"`
+	Authors []string `json:"authors"`
+	Title   string   `json:"title"`
+	Series  string   `json:"series"`
+	// SeriesIndex is the book's position in its series, which can be fractional, as in 2.5 for a novella. It is 0 if unknown.
+	SeriesIndex float64    `json:"series_index,omitempty"`
+	Files       []BookFile `json:"files"`
+	Reading     Reading    `json:"reading"`
+	// Identifiers holds the book's ISBNs and other catalog identifiers, sorted by type and value.
+	Identifiers []Identifier `json:"identifiers,omitempty"`
+	Publisher   string       `json:"publisher,omitempty"`
+	// Published is the publication date, as precise as it's known: a year, YYYY-MM, or YYYY-MM-DD.
+	Published string `json:"published,omitempty"`
+	// Language is a language code, such as en or en-US.
+	Language    string   `json:"language,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Subjects    []string `json:"subjects,omitempty"`
+	// Cover is the file name of the book's cover in the library's covers directory, or empty if it has none.
+	// Use Library.CoverFilename to get its full path.
+	Cover string `json:"cover,omitempty"`
+	// Shelves holds the names of the shelves the book is on, sorted by name.
+	Shelves []string `json:"shelves,omitempty"`
+	// Fields maps the names of custom fields to the book's values for them, stored as described in CustomField.Normalize.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Snippet is set on books returned from a search to the text that best matched, with matches surrounded by HighlightStart and HighlightEnd.
+	// JSON output from the books command and the API gives it as HTML, converted with HighlightHTML.
+	Snippet string `json:"snippet,omitempty"`
 }
 
 // BookFile represents a file linked to a book.
 type BookFile struct {
-	ID               int64
-	Extension        string
-	Tags             []string
-	Hash             string
-	OriginalFilename string
-	CurrentFilename  string
-	FileMtime        time.Time
-	FileSize         int64
-	Source           string
+	ID               int64     `json:"id"`
+	Extension        string    `json:"extension"`
+	Tags             []string  `json:"tags"`
+	Hash             string    `json:"hash"`
+	OriginalFilename string    `json:"original_filename"`
+	CurrentFilename  string    `json:"current_filename"`
+	FileMtime        time.Time `json:"file_mtime"`
+	FileSize         int64     `json:"file_size"`
+	Source           string    `json:"source"`
+	// TemplateOverride, if set, is used instead of the configured output template when naming this file.
+	TemplateOverride string `json:"template_override"`
 }
 
 // Filename retrieves a book's correct filename, based on the given output template.
 func (bf *BookFile) Filename(tmpl *template.Template, book *Book) (string, error) {
 	var fnBuff bytes.Buffer
+	// The template is never encoded as JSON, where the embedded IDs would conflict.
 	type FilenameTemplate struct {
-		Book
-		BookFile
+		Book         `json:"-"`
+		BookFile     `json:"-"`
 		AuthorsShort string
 	}
 	ft := FilenameTemplate{*book, *bf, "Unknown"}
@@ -65,6 +90,35 @@ func (bf *BookFile) Filename(tmpl *template.Template, book *Book) (string, error
 	return fnBuff.String(), nil
 }
 
+// TruncateFilename shortens each component of a relative filename so that it fits within common filesystem limits,
+// keeping the extension of the final component.
+func TruncateFilename(fn string) string {
+	var lst []string
+	dirs, fn := path.Split(fn)
+	if dirs != "" {
+		dirs = strings.TrimRight(dirs, string(os.PathSeparator))
+		lst = strings.Split(dirs, string(os.PathSeparator))
+	}
+	for i, f := range lst {
+		if len(f) <= 255 {
+			continue
+		}
+
+		l := len(f)
+		if l > 255 {
+			l = 255
+		}
+		lst[i] = f[:l]
+	}
+	if len(fn) > 250 {
+		ext := path.Ext(fn)
+		nameLen := 250 - len(ext)
+		fn = fn[:nameLen] + ext
+	}
+	lst = append(lst, fn)
+	return strings.Join(lst, string(os.PathSeparator))
+}
+
 // CalculateHash calculates the hash of b.OriginalFilename and updates book.Hash.
 // If a value is stored in the user.hash xattr, that value will be used instead of hashing the file's contents.
 func (b *BookFile) CalculateHash() error {
@@ -89,7 +143,7 @@ func (b *BookFile) CalculateHash() error {
 }
 
 // ParseFilename creates a new Book given a filename and regular expression.
-// The named groups author, title, series, and extension in the regular expression will map to their respective fields in the resulting book.
+// The named groups author, title, series, series_index, and extension in the regular expression will map to their respective fields in the resulting book.
 func ParseFilename(filename string, re *regexp.Regexp) (Book, bool) {
 	result := Book{}
 	bf := BookFile{}
@@ -103,6 +157,7 @@ func ParseFilename(filename string, re *regexp.Regexp) (Book, bool) {
 	}
 	result.Title = mapping["title"]
 	result.Series = mapping["series"]
+	result.SeriesIndex = parseSeriesIndex(mapping["series_index"])
 	bf.Extension = mapping["ext"]
 	result.Files = append(result.Files, bf)
 	return result, true
@@ -0,0 +1,208 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// A Category is something books can be browsed by, such as an author or series, with the number of books in it.
+// ID is 0 for categories which are only identified by name, such as series.
+type Category struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Books int    `json:"books"`
+}
+
+// GetAuthors returns every author in the library, ordered by sort name.
+func (lib *Library) GetAuthors() ([]Category, error) {
+	return lib.getCategories(`select a.id, a.name, count(ba.book_id) from authors a
+	join books_authors ba on ba.author_id = a.id
+	group by a.id order by a.sort_name collate nocase, a.name collate nocase`)
+}
+
+// GetAuthor returns the author with the given ID, and false if there isn't one.
+func (lib *Library) GetAuthor(id int64) (Category, bool, error) {
+	c := Category{ID: id}
+	err := lib.QueryRow(`select a.name, count(ba.book_id) from authors a
+	left join books_authors ba on ba.author_id = a.id
+	where a.id = ? group by a.id`, id).Scan(&c.Name, &c.Books)
+	if err == sql.ErrNoRows {
+		return c, false, nil
+	} else if err != nil {
+		return c, false, errors.Wrap(err, "get author")
+	}
+	return c, true, nil
+}
+
+// GetSeries returns every series in the library, ordered by name.
+func (lib *Library) GetSeries() ([]Category, error) {
+	return lib.getCategories(`select 0, series, count(*) from books
+	where series is not null and series != ''
+	group by series order by series collate nocase`)
+}
+
+// GetTags returns every tag in the library, with the number of books which have a file with that tag, ordered by name.
+func (lib *Library) GetTags() ([]Category, error) {
+	return lib.getCategories(`select t.id, t.name, count(distinct f.book_id) from tags t
+	join files_tags ft on ft.tag_id = t.id
+	join files f on f.id = ft.file_id
+	group by t.id order by t.name collate nocase`)
+}
+
+func (lib *Library) getCategories(query string) ([]Category, error) {
+	rows, err := lib.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "get categories")
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Books); err != nil {
+			return nil, errors.Wrap(err, "get categories")
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// GetBooksByAuthor returns the books by the author with the given ID, ordered by series, series index and title.
+func (lib *Library) GetBooksByAuthor(authorID int64) ([]Book, error) {
+	return lib.getBooksByQuery(`select b.id from books b join books_authors ba on ba.book_id = b.id
+	where ba.author_id = ? order by b.series collate nocase, b.series_index = 0, b.series_index, b.title collate nocase`, authorID)
+}
+
+// A BookGroup is a list of books in the same series. Series is empty for books which aren't in a series.
+type BookGroup struct {
+	Series string `json:"series"`
+	Books  []Book `json:"books"`
+}
+
+// GroupBySeries groups books by series, keeping the order of the books and of the series they're in.
+// Books which aren't in a series are grouped last.
+func GroupBySeries(books []Book) []BookGroup {
+	groups := []BookGroup{}
+	index := make(map[string]int)
+	var other []Book
+	for _, b := range books {
+		if b.Series == "" {
+			other = append(other, b)
+			continue
+		}
+		i, ok := index[b.Series]
+		if !ok {
+			i = len(groups)
+			index[b.Series] = i
+			groups = append(groups, BookGroup{Series: b.Series})
+		}
+		groups[i].Books = append(groups[i].Books, b)
+	}
+	if len(other) > 0 {
+		groups = append(groups, BookGroup{Books: other})
+	}
+	return groups
+}
+
+// GetBooksInSeries returns the books in a series, ordered by series index and then title.
+// Books without a series index come last.
+func (lib *Library) GetBooksInSeries(series string) ([]Book, error) {
+	return lib.getBooksByQuery("select id from books where series = ? order by series_index = 0, series_index, title collate nocase", series)
+}
+
+// A Series is a series of books, in order, along with the volumes missing from it.
+type Series struct {
+	Name  string `json:"name"`
+	Books []Book `json:"books"`
+	// Missing holds the whole numbers from 1 to the highest series index which no book in the series has.
+	Missing []int `json:"missing"`
+}
+
+// A Volume is a single position in a series. Book is nil if the volume is missing from the library.
+type Volume struct {
+	Index float64
+	Book  *Book
+}
+
+// GetSeriesVolumes returns the books in a series in order, and finds the volumes missing from it.
+// It returns false if the library has no books in the series.
+func (lib *Library) GetSeriesVolumes(name string) (Series, bool, error) {
+	books, err := lib.GetBooksInSeries(name)
+	if err != nil {
+		return Series{}, false, err
+	}
+	if len(books) == 0 {
+		return Series{Name: name, Books: []Book{}, Missing: []int{}}, false, nil
+	}
+	return Series{Name: name, Books: books, Missing: missingVolumes(books)}, true, nil
+}
+
+// Volumes returns the books in the series along with the missing volumes, ordered by series index.
+// Books without a series index come last, with an index of 0.
+func (s Series) Volumes() []Volume {
+	var volumes []Volume
+	missing := s.Missing
+	for i := range s.Books {
+		b := &s.Books[i]
+		for len(missing) > 0 && b.SeriesIndex != 0 && float64(missing[0]) < b.SeriesIndex {
+			volumes = append(volumes, Volume{Index: float64(missing[0])})
+			missing = missing[1:]
+		}
+		volumes = append(volumes, Volume{Index: b.SeriesIndex, Book: b})
+	}
+	for _, m := range missing {
+		volumes = append(volumes, Volume{Index: float64(m)})
+	}
+	return volumes
+}
+
+// missingVolumes returns the whole numbers from 1 to the highest series index of books which aren't the index of any book.
+// Fractional indexes, such as 2.5, are never considered missing.
+func missingVolumes(books []Book) []int {
+	have := make(map[int]bool)
+	highest := 0
+	for _, b := range books {
+		if b.SeriesIndex == float64(int(b.SeriesIndex)) {
+			have[int(b.SeriesIndex)] = true
+		}
+		if int(b.SeriesIndex) > highest {
+			highest = int(b.SeriesIndex)
+		}
+	}
+	missing := []int{}
+	for i := 1; i <= highest; i++ {
+		if !have[i] {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// GetRecentBooks returns up to limit of the most recently added books, newest first.
+func (lib *Library) GetRecentBooks(limit int) ([]Book, error) {
+	return lib.getBooksByQuery("select id from books order by created_on desc, id desc limit ?", limit)
+}
+
+// getBooksByQuery returns the books whose IDs are selected by query, in the same order.
+func (lib *Library) getBooksByQuery(query string, args ...interface{}) ([]Book, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "get transaction")
+	}
+	defer tx.Rollback()
+
+	ids, err := queryIDs(tx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "get book IDs")
+	}
+	books, err := getBooksByID(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	return sortBooksByID(books, ids), nil
+}
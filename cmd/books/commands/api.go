@@ -0,0 +1,183 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// apiMaxPerPage is the largest number of results the API returns in one page.
+const apiMaxPerPage = 100
+
+// apiSearchResults is the response to a search through the API.
+type apiSearchResults struct {
+	Query string `json:"query"`
+	// Books holds the results, whose snippets are HTML with the matching text in <mark> tags.
+	Books   []books.Book `json:"books"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"per_page"`
+	// MoreResults is the number of results after this page, counting at most ten more pages.
+	MoreResults int `json:"more_results"`
+	// NextPage is the number of the next page, or 0 if this is the last page.
+	NextPage int `json:"next_page"`
+	// Suggestions holds similar queries if Query had no results; Books holds the results of the first one.
+	Suggestions []string `json:"suggestions"`
+}
+
+// apiError is the response to an API request that failed.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// registerAPI adds the JSON API to r, under /api/v1/.
+// It has endpoints for searching (search?query=QUERY&page=N&per_page=N), getting a book (books/ID),
+// listing a book's files (books/ID/files), and downloading a file (files/ID/download).
+func registerAPI(r *mux.Router, h *libHandler) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/search", h.apiSearchHandler).Methods("GET")
+	api.HandleFunc("/books/{id:\\d+}", h.apiBookHandler).Methods("GET")
+	api.HandleFunc("/books/{id:\\d+}/files", h.apiBookFilesHandler).Methods("GET")
+	api.HandleFunc("/files/{id:\\d+}/download", h.apiDownloadHandler).Methods("GET")
+	api.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "No such endpoint")
+	})
+}
+
+func (h *libHandler) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	page, perPage := 1, itemsPerPage
+	if s := r.URL.Query().Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, "page must be a positive number")
+			return
+		}
+		page = n
+	}
+	if s := r.URL.Query().Get("per_page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			writeAPIError(w, http.StatusBadRequest, "per_page must be between 1 and "+strconv.Itoa(apiMaxPerPage))
+			return
+		}
+		perPage = n
+	}
+
+	found, moreResults, suggestions, err := h.lib.SearchPaged(query, (page-1)*perPage, perPage, perPage*10)
+	if err != nil {
+		if qe, ok := err.(books.QueryError); ok {
+			writeAPIError(w, http.StatusBadRequest, qe.Error())
+			return
+		}
+		log.Printf("Error searching for %s: %s", query, err)
+		writeAPIError(w, http.StatusInternalServerError, "An error occurred while searching")
+		return
+	}
+	for i := range found {
+		found[i].Snippet = books.HighlightHTML(found[i].Snippet)
+	}
+	res := apiSearchResults{
+		Query:       query,
+		Books:       found,
+		Page:        page,
+		PerPage:     perPage,
+		MoreResults: moreResults,
+		Suggestions: suggestions,
+	}
+	if res.Suggestions == nil {
+		res.Suggestions = []string{}
+	}
+	if moreResults > 0 {
+		res.NextPage = page + 1
+	}
+	writeAPIResponse(w, res)
+}
+
+func (h *libHandler) apiBookHandler(w http.ResponseWriter, r *http.Request) {
+	if book, ok := h.apiGetBook(w, r); ok {
+		writeAPIResponse(w, book)
+	}
+}
+
+func (h *libHandler) apiBookFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if book, ok := h.apiGetBook(w, r); ok {
+		writeAPIResponse(w, book.Files)
+	}
+}
+
+// apiGetBook returns the book whose ID is in the request's path.
+// If it can't be found, an error is written and ok is false.
+func (h *libHandler) apiGetBook(w http.ResponseWriter, r *http.Request) (book books.Book, ok bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "Book not found")
+		return book, false
+	}
+	found, err := h.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting books by ID: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "An error occurred while getting the book")
+		return book, false
+	}
+	if len(found) == 0 {
+		writeAPIError(w, http.StatusNotFound, "Book not found")
+		return book, false
+	}
+	return found[0], true
+}
+
+func (h *libHandler) apiDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	files, err := h.lib.GetFilesByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting files by ID: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "An error occurred while getting the file")
+		return
+	}
+	if len(files) == 0 {
+		writeAPIError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	file := files[0]
+
+	fn := path.Join(booksRoot, file.CurrentFilename)
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		log.Printf("File %d is in the library but the file is missing: %s", file.ID, fn)
+		writeAPIError(w, http.StatusNotFound, "The file is in the library, but is missing from disk")
+		return
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+path.Base(fn)+"\"")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, fn)
+}
+
+// writeAPIResponse writes v to w as JSON.
+func writeAPIResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing API response: %s", err)
+	}
+}
+
+// writeAPIError writes an error to w as JSON, with the given HTTP status.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(apiError{message}); err != nil {
+		log.Printf("Error writing API error: %s", err)
+	}
+}
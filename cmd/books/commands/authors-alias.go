@@ -0,0 +1,78 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsAliasCmd represents the authors alias command
+var authorsAliasCmd = &cobra.Command{
+	Use:   "alias [ALIAS AUTHOR]",
+	Short: "List aliases, or add an alias for an author",
+	Long: `With no arguments, list every alias and the author it refers to.
+
+Otherwise, make ALIAS another name for AUTHOR. Books imported or edited with the alias are added to the author.
+If an author named ALIAS is already in the library, its books are moved to AUTHOR and their files are renamed.
+Books which then have the same title and authors as another book are merged into it.
+
+Example:
+    books authors alias "Richard Bachman" "Stephen King"`,
+	Run: CPUProfile(authorsAliasRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsAliasCmd)
+}
+
+func authorsAliasRun(cmd *cobra.Command, args []string) {
+	if len(args) != 0 && len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Specify both an alias and an author.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if len(args) == 2 {
+		ids, err := lib.AddAuthorAlias(args[0], args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error adding alias: %s\n", err)
+			os.Exit(1)
+		}
+		renameBookFiles(lib, tmpl, ids)
+		return
+	}
+
+	out := mustOutputWriter("{{.Alias}} => {{.Author}}\n", true)
+	aliases, err := lib.GetAuthorAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting aliases: %s\n", err)
+		os.Exit(1)
+	}
+	for _, alias := range aliases {
+		if err := out.Write(alias); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing alias: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing alias: %s\n", err)
+		os.Exit(1)
+	}
+}
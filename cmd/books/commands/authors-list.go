@@ -0,0 +1,51 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsListCmd represents the authors list command
+var authorsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List authors",
+	Long:  `List every author in the library by sort name, with the number of books by each.`,
+	Run:   CPUProfile(authorsListRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsListCmd)
+}
+
+func authorsListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	out := mustOutputWriter("{{.Name}} ({{.Books}})\n", true)
+	authors, err := lib.GetAuthors()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting authors: %s\n", err)
+		os.Exit(1)
+	}
+	for _, author := range authors {
+		if err := out.Write(author); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing author: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing author: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsMergeCmd represents the authors merge command
+var authorsMergeCmd = &cobra.Command{
+	Use:   "merge AUTHOR... INTO",
+	Short: "Merge authors into one",
+	Long: `Move the books and aliases of each AUTHOR to the last author given, and remove them.
+The last author is added if they aren't in the library yet. The files of the moved books are renamed.
+
+Books which then have the same title and authors as another book are merged into it.
+
+Example:
+    books authors merge "Stephen Kng" "S. King" "Stephen King"`,
+	Run: CPUProfile(authorsMergeRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsMergeCmd)
+}
+
+func authorsMergeRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Specify the authors to merge and the author to merge them into.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	ids, err := lib.MergeAuthors(args[:len(args)-1], args[len(args)-1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging authors: %s\n", err)
+		os.Exit(1)
+	}
+	renameBookFiles(lib, tmpl, ids)
+}
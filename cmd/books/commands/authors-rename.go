@@ -0,0 +1,56 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsRenameCmd represents the authors rename command
+var authorsRenameCmd = &cobra.Command{
+	Use:   "rename OLD NEW",
+	Short: "Rename an author",
+	Long: `Change the name of an author on all of their books, and rename the books' files.
+
+If NEW is already the name of another author, use books authors merge instead.
+
+Example:
+    books authors rename "Stephen Kng" "Stephen King"`,
+	Run: CPUProfile(authorsRenameRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsRenameCmd)
+}
+
+func authorsRenameRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Specify the author to rename and their new name.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	ids, err := lib.RenameAuthor(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming author: %s\n", err)
+		os.Exit(1)
+	}
+	renameBookFiles(lib, tmpl, ids)
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsSortNameCmd represents the authors sort-name command
+var authorsSortNameCmd = &cobra.Command{
+	Use:   "sort-name AUTHOR [SORT_NAME]",
+	Short: "Show or set the sort name of an author",
+	Long: `Show the name an author is sorted by, or set it to SORT_NAME.
+
+Sort names are derived by putting the last word of the name first, as in "King, Stephen".
+Set a sort name for names where that's wrong, such as "Le Guin, Ursula K.".
+Pass an empty SORT_NAME to derive it from the author's name again.
+
+Example:
+    books authors sort-name "Ursula K. Le Guin" "Le Guin, Ursula K."`,
+	Run: CPUProfile(authorsSortNameRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsSortNameCmd)
+}
+
+func authorsSortNameRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "No author specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if len(args) == 2 {
+		if err := lib.SetAuthorSortName(args[0], args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting sort name: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	name, sortName, found, err := lib.GetAuthorSortName(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting sort name: %s\n", err)
+		os.Exit(1)
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "Author %s not found.\n", args[0])
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %s\n", name, sortName)
+}
@@ -0,0 +1,46 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsUnaliasCmd represents the authors unalias command
+var authorsUnaliasCmd = &cobra.Command{
+	Use:   "unalias ALIAS",
+	Short: "Remove an author alias",
+	Long: `Remove an alias, so that books imported with it get an author of that name again.
+
+Books already added to the author through the alias keep their author.`,
+	Run: CPUProfile(authorsUnaliasRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsUnaliasCmd)
+}
+
+func authorsUnaliasRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "No alias specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.RemoveAuthorAlias(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing alias: %s\n", err)
+		os.Exit(1)
+	}
+}
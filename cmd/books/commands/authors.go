@@ -0,0 +1,24 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// authorsCmd represents the authors command
+var authorsCmd = &cobra.Command{
+	Use:   "authors",
+	Short: "Manage authors",
+	Long: `List, rename and merge authors, and manage their sort names and aliases.
+
+Each author has a sort name, such as "King, Stephen", which is derived from their name when they're added.
+Aliases map pen names and variant spellings to one author, so that importing a book by "Richard Bachman"
+adds it to Stephen King instead of creating a new author.`,
+}
+
+func init() {
+	rootCmd.AddCommand(authorsCmd)
+}
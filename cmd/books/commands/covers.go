@@ -0,0 +1,72 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// coversCmd represents the covers command
+var coversCmd = &cobra.Command{
+	Use:   "covers [BOOK_ID...]",
+	Short: "Extract covers for books which don't have one",
+	Long: `Extract covers for the given books, or for every book which doesn't have a cover.
+
+Covers are normally extracted when books are imported. This finds covers for books imported before covers were supported,
+or whose cover couldn't be read at the time.
+A cover is taken from an EPUB's manifest, an image next to a book file with the same name,
+or named cover.jpg or cover.png if it's the only book in its directory,
+or the first page of a PDF if pdftoppm is installed.`,
+	Run: CPUProfile(coversRun),
+}
+
+func init() {
+	rootCmd.AddCommand(coversCmd)
+}
+
+func coversRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid book ID: %s\n", arg)
+			os.Exit(1)
+		}
+		ids = append(ids, id)
+	}
+	if len(args) == 0 {
+		if ids, err = lib.GetBookIDsWithoutCover(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting books: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	found := 0
+	for i, id := range ids {
+		ok, err := lib.UpdateCover(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nError extracting cover for book %d: %s\n", id, err)
+			continue
+		}
+		if ok {
+			found++
+		}
+		fmt.Fprintf(os.Stderr, "\rChecked %d of %d books", i+1, len(ids))
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Printf("Found covers for %d of %d books.\n", found, len(ids))
+}
@@ -50,12 +50,21 @@ func editFunc(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 	library, err := books.OpenLibrary(libraryFile, booksRoot)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening library: %s\n", err)
 		os.Exit(1)
 	}
 	defer library.Close()
+	if err := loadCustomFields(library); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading custom fields: %s\n", err)
+		os.Exit(1)
+	}
 
 	var bookID int64
 	if useFile {
@@ -95,7 +104,7 @@ func editFunc(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 	book := books[0]
-	parser := edit.NewParser(&book, library)
+	parser := edit.NewParser(&book, library, tmpl)
 	parser.RunCommand("show", "")
 	line := liner.NewLiner()
 	defer line.Close()
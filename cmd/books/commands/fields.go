@@ -0,0 +1,78 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// fieldsCmd represents the fields command
+var fieldsCmd = &cobra.Command{
+	Use:   "fields",
+	Short: "List custom fields",
+	Long: `List the custom fields declared in the config file.
+
+Custom fields hold extra information about books, such as a translator or edition.
+Each one has a name and a type: text, int, bool, date (YYYY-MM-DD), or enum, which needs a list of values.
+Declare them in config.toml like this:
+
+    [[fields]]
+    name = "translator"
+    type = "text"
+
+    [[fields]]
+    name = "quality"
+    type = "enum"
+    values = ["poor", "good", "excellent"]
+
+Set them with the field command of books edit, and search for them with name:value, as in quality:good.
+int and date fields can be compared, as in pages:>500. In output templates, use {{.Fields.translator}}.`,
+	Run: CPUProfile(fieldsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(fieldsCmd)
+}
+
+func fieldsRun(cmd *cobra.Command, args []string) {
+	out := mustOutputWriter(`{{.Name}} ({{.Type}}){{if .Values}}: {{join .Values ", "}}{{end}}
+`, true)
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+	if err := loadCustomFields(lib); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading custom fields: %s\n", err)
+		os.Exit(1)
+	}
+
+	for _, f := range lib.CustomFields() {
+		if err := out.Write(f); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing field: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing field: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadCustomFields sets the custom fields of lib to those declared in the config file.
+func loadCustomFields(lib *books.Library) error {
+	var fields []books.CustomField
+	if err := viper.UnmarshalKey("fields", &fields); err != nil {
+		return errors.Wrap(err, "read custom fields from config file")
+	}
+	return lib.SetCustomFields(fields)
+}
@@ -0,0 +1,115 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+var fsckRepair bool
+var fsckSkipHashes bool
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the library for problems",
+	Long: `Check the library for problems, and optionally repair them.
+
+The following problems are reported:
+    missing files, and files whose size or hash no longer match the library
+    files in the books root that aren't in the library
+    books with no files, and files with no book
+    books missing from the search index, and search rows for books that don't exist
+    links to authors and tags that don't exist, and authors and tags nothing links to
+
+With --repair, problems that can be fixed without touching files on disk are repaired.
+Problems with files on disk are only reported, as are books with no files, which may be kept on purpose;
+remove any that aren't wanted with books rm.`,
+	Run: CPUProfile(fsckRun),
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+
+	fsckCmd.Flags().BoolVarP(&fsckRepair, "repair", "r", false, "Repair problems that can be fixed safely")
+	fsckCmd.Flags().BoolVarP(&fsckSkipHashes, "skip-hashes", "s", false, "Don't check file hashes, which requires reading every file")
+}
+
+// fsckResult is a problem found by fsck, as printed when --format isn't text.
+type fsckResult struct {
+	books.Problem
+	Repairable bool `json:"repairable"`
+	Repaired   bool `json:"repaired"`
+}
+
+func fsckRun(cmd *cobra.Command, args []string) {
+	out := mustOutputWriter("", true)
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	problems, err := lib.Check(!fsckSkipHashes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking library: %s\n", err)
+		os.Exit(1)
+	}
+	if textOutput() {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	}
+
+	remaining := len(problems)
+	repaired := []books.Problem{}
+	if fsckRepair && remaining > 0 {
+		repaired, err = lib.Repair(problems)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error repairing library: %s\n", err)
+			os.Exit(1)
+		}
+		if textOutput() {
+			fmt.Printf("Repaired %d problems\n", len(repaired))
+		}
+		remaining -= len(repaired)
+	}
+
+	if !textOutput() {
+		// Repair returns the problems it repaired in the order they were given.
+		for _, p := range problems {
+			r := fsckResult{Problem: p, Repairable: p.Repairable()}
+			if len(repaired) > 0 && repaired[0] == p {
+				r.Repaired = true
+				repaired = repaired[1:]
+			}
+			if err := out.Write(r); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing results: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := out.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %s\n", err)
+			os.Exit(1)
+		}
+		if remaining > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if remaining > 0 {
+		fmt.Printf("%d problems remaining\n", remaining)
+		os.Exit(1)
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+	}
+}
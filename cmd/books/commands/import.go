@@ -30,6 +30,15 @@ var metadataParsers []string
 var metadataParserMap map[string]books.MetadataParser
 var tagsRegexp = regexp.MustCompile(`^(.*)\(([^)]+)\)\s*$`)
 
+// importResult is the outcome of importing a single file, printed for each file when --format isn't text.
+// Status is imported, duplicate, or error.
+type importResult struct {
+	Filename string      `json:"filename"`
+	Status   string      `json:"status"`
+	Book     *books.Book `json:"book,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
 // importCmd represents the import command
 var importCmd = &cobra.Command{
 	Use:   "import",
@@ -41,7 +50,8 @@ If a directory is passed, the books in that directory will be imported,
 but the books in its subdirectories will not be, unless --recursive is set.
 
 Each file will be matched against the list of regular expressions in order, and will be imported according to the first match.
-The following named groups will be recognized: author, series, title, and ext.
+The following named groups will be recognized: author, series, series_index, title, and ext.
+series_index is the position of the book in its series, such as 2 or 2.5.
 Your files will be named according to the output template in the config file,
 or the template override set in the library.`,
 	Run: CPUProfile(importFunc),
@@ -64,49 +74,16 @@ func importFunc(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "No files to import.\n")
 		os.Exit(1)
 	}
+	out := mustOutputWriter("", true)
 
-	// Get regular expressions by their names and compile them.
-	res := viper.GetStringSlice("default_Regexps")
-	if len(res) == 0 {
-		fmt.Fprintf(os.Stderr, "Either -r must be specified, or default_regexps must be set in the configuration file.\n")
-		os.Exit(1)
-	}
-
-	for _, v := range res {
-		reString := viper.GetString("regexps." + v)
-		if reString == "" {
-			fmt.Fprintf(os.Stderr, "Regexp %s not found in config\n", v)
-			os.Exit(1)
-		}
-		regexpNames = append(regexpNames, v)
-		c, err := regexp.Compile(reString)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Cannot compile regular expression %s: %s\n", v, err)
-			os.Exit(1)
-		}
-		compiled = append(compiled, c)
-	}
-
-	metadataParserMap = make(map[string]books.MetadataParser)
-	metadataParserMap["regexp"] = &books.RegexpMetadataParser{compiled, regexpNames}
-	metadataParserMap["epub"] = &books.EpubMetadataParser{}
-	metadataParsers = viper.GetStringSlice("default_metadata_parsers")
-	for _, name := range metadataParsers {
-		if _, ok := metadataParserMap[name]; !ok {
-			fmt.Fprintf(os.Stderr, "Metadata parser %s not found.\n", name)
-			os.Exit(1)
-		}
-	}
-	if len(metadataParsers) == 0 {
-		fmt.Fprintf(os.Stderr, "No metadata parsers defined.\n")
+	if err := loadMetadataParsers(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
-	log.Printf("Using metadata parsers: %v\n", metadataParsers)
-	outputTmplSrc := viper.GetString("output_template")
 	var err error
-	outputTmpl, err = template.New("filename").Funcs(template.FuncMap{"ToUpper": strings.ToUpper, "join": strings.Join, "escape": escape}).Parse(outputTmplSrc)
+	outputTmpl, err = loadOutputTemplate()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
@@ -118,16 +95,20 @@ func importFunc(cmd *cobra.Command, args []string) {
 	defer library.Close()
 
 	for _, path := range args {
-		if err := importBooks(path, recursive, library); err != nil {
+		if err := importBooks(path, recursive, library, out); err != nil {
 			fmt.Fprintf(os.Stderr, "Cannot import books from %s: %s; skipping\n", path, err)
 			continue
 		}
 	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing results: %s\n", err)
+		os.Exit(1)
+	}
 }
 
-// importBooks imports one or more books into the library.
+// importBooks imports one or more books into the library, and writes the result for each file to out.
 // root may be either a file or directory.
-func importBooks(root string, recursive bool, library *books.Library) error {
+func importBooks(root string, recursive bool, library *books.Library, out *outputWriter) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -135,10 +116,18 @@ func importBooks(root string, recursive bool, library *books.Library) error {
 
 		if !info.IsDir() {
 			log.Printf("Importing file %s:\n", path)
-			if err := importBook(path, library); err != nil {
+			result := importResult{Filename: path, Status: "imported"}
+			id, err := importBook(path, library, viper.GetBool("move"))
+			if err != nil {
 				log.Printf("Cannot import book from %s: %s; skipping\n", path, err)
+				result.Status, result.Error = "error", err.Error()
+				if _, ok := errors.Cause(err).(books.DuplicateFileError); ok {
+					result.Status = "duplicate"
+				}
+			} else if bks, err := library.GetBooksByID([]int64{id}); err == nil && len(bks) == 1 {
+				result.Book = &bks[0]
 			}
-			return nil
+			return out.Write(result)
 		}
 
 		if path != root && !recursive {
@@ -149,27 +138,76 @@ func importBooks(root string, recursive bool, library *books.Library) error {
 	})
 }
 
-// importBook imports a single book into the library.
-func importBook(filename string, library *books.Library) error {
-	fi, err := os.Stat(filename)
-	if err != nil {
-		return errors.Wrap(err, "Get file info for book")
+// loadMetadataParsers compiles the regular expressions named by default_regexps,
+// and sets up the metadata parsers named by default_metadata_parsers for importBook to use.
+func loadMetadataParsers() error {
+	// Get regular expressions by their names and compile them.
+	res := viper.GetStringSlice("default_Regexps")
+	if len(res) == 0 {
+		return errors.New("Either -r must be specified, or default_regexps must be set in the configuration file.")
 	}
 
-	tags := splitTags(filename)
-	ext := path.Ext(filename)
-	var book books.Book
-	var matched bool
+	compiled, regexpNames = nil, nil
+	for _, v := range res {
+		reString := viper.GetString("regexps." + v)
+		if reString == "" {
+			return errors.Errorf("Regexp %s not found in config", v)
+		}
+		regexpNames = append(regexpNames, v)
+		c, err := regexp.Compile(reString)
+		if err != nil {
+			return errors.Errorf("Cannot compile regular expression %s: %s", v, err)
+		}
+		compiled = append(compiled, c)
+	}
+
+	metadataParserMap = make(map[string]books.MetadataParser)
+	metadataParserMap["regexp"] = &books.RegexpMetadataParser{compiled, regexpNames}
+	metadataParserMap["epub"] = &books.EpubMetadataParser{}
+	metadataParsers = viper.GetStringSlice("default_metadata_parsers")
+	for _, name := range metadataParsers {
+		if _, ok := metadataParserMap[name]; !ok {
+			return errors.Errorf("Metadata parser %s not found.", name)
+		}
+	}
+	if len(metadataParsers) == 0 {
+		return errors.New("No metadata parsers defined.")
+	}
+	log.Printf("Using metadata parsers: %v\n", metadataParsers)
+	return nil
+}
+
+// importBook imports a single book into the library, and returns the ID of the book it was added to.
+// If move is true, the file is moved into the books root instead of being copied.
+func importBook(filename string, library *books.Library, move bool) (int64, error) {
+	book, matched := parseMetadata(filename)
+	if !matched {
+		return 0, errors.Errorf("No metadata parser matched %s", filename)
+	}
+	return importParsedBook(filename, book, library, move)
+}
+
+// parseMetadata runs the metadata parsers in order on filename, and returns the book from the first one that matches.
+func parseMetadata(filename string) (books.Book, bool) {
 	for _, parserName := range metadataParsers {
-		if book, matched = metadataParserMap[parserName].Parse([]string{filename}); matched {
+		if book, matched := metadataParserMap[parserName].Parse([]string{filename}); matched {
 			log.Printf("Matched metadata parser: %s", parserName)
-			break
+			return book, true
 		}
 	}
-	if !matched {
-		return errors.Errorf("No metadata parser matched %s", filename)
+	return books.Book{}, false
+}
+
+// importParsedBook imports filename into the library as a file of book, whose metadata has already been set.
+// It returns the ID of the book it was added to.
+func importParsedBook(filename string, book books.Book, library *books.Library, move bool) (int64, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return 0, errors.Wrap(err, "Get file info for book")
 	}
 
+	tags := splitTags(filename)
+	ext := path.Ext(filename)
 	bf := books.BookFile{Tags: tags, OriginalFilename: filename}
 	bf.FileSize = fi.Size()
 	bf.FileMtime = fi.ModTime()
@@ -177,27 +215,39 @@ func importBook(filename string, library *books.Library) error {
 
 	err = bf.CalculateHash()
 	if err != nil {
-		return errors.Wrap(err, "Calculate book hash")
+		return 0, errors.Wrap(err, "Calculate book hash")
 	}
 
 	s, err := bf.Filename(outputTmpl, &book)
 	if err != nil {
-		return errors.Wrap(err, "Calculate output filename for book")
+		return 0, errors.Wrap(err, "Calculate output filename for book")
 	}
-	s = truncateFilename(s)
+	s = books.TruncateFilename(s)
 	newFilename := books.GetUniqueName(filepath.Join(booksRoot, s))
 	bf.CurrentFilename, err = filepath.Rel(booksRoot, newFilename)
 	if err != nil {
-		return errors.Wrap(err, "get new book filename")
+		return 0, errors.Wrap(err, "get new book filename")
 	}
 	bf.CurrentFilename = strings.Replace(bf.CurrentFilename, string(filepath.Separator), "/", -1)
 	book.Files = append(book.Files, bf)
 
-	if err := library.ImportBook(book, viper.GetBool("move")); err != nil {
-		return errors.Wrap(err, "Import book into library")
+	id, err := library.ImportBook(book, move)
+	if err != nil {
+		return 0, errors.Wrap(err, "Import book into library")
 	}
 
-	return nil
+	return id, nil
+}
+
+// loadOutputTemplate parses the output template from the config file, which is used to name files in the books root.
+// Custom fields a book has no value for, such as {{.Fields.translator}}, are empty.
+func loadOutputTemplate() (*template.Template, error) {
+	outputTmplSrc := viper.GetString("output_template")
+	tmpl, err := template.New("filename").Funcs(template.FuncMap{"ToUpper": strings.ToUpper, "join": strings.Join, "escape": escape}).Option("missingkey=zero").Parse(outputTmplSrc)
+	if err != nil {
+		return nil, errors.Errorf("Cannot parse output template: %s\n\n%s", err, outputTmplSrc)
+	}
+	return tmpl, nil
 }
 
 // SplitTags takes an unsplit filename in the form "filename (tag1) (tag2)..."
@@ -228,30 +278,3 @@ func escape(filename string) string {
 	}
 	return newFilename
 }
-
-func truncateFilename(fn string) string {
-	var lst []string
-	dirs, fn := path.Split(fn)
-	if dirs != "" {
-		dirs = strings.TrimRight(dirs, string(os.PathSeparator))
-		lst = strings.Split(dirs, string(os.PathSeparator))
-	}
-	for i, f := range lst {
-		if len(f) <= 255 {
-			continue
-		}
-
-		l := len(f)
-		if l > 255 {
-			l = 255
-		}
-		lst[i] = f[:l]
-	}
-	if len(fn) > 250 {
-		ext := path.Ext(fn)
-		nameLen := 250 - len(ext)
-		fn = fn[:nameLen] + ext
-	}
-	lst = append(lst, fn)
-	return strings.Join(lst, string(os.PathSeparator))
-}
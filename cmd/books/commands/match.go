@@ -11,7 +11,6 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"text/template"
 
 	"fmt"
 
@@ -22,6 +21,12 @@ import (
 	"github.com/spf13/viper"
 )
 
+// matchResult is a file which matched a book already in the library.
+type matchResult struct {
+	Filename string     `json:"filename"`
+	Book     books.Book `json:"book"`
+}
+
 // matchCmd represents the match command
 var matchCmd = &cobra.Command{
 	Use:   "match",
@@ -48,6 +53,7 @@ func matchFunc(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "No files to search.\n")
 		os.Exit(1)
 	}
+	out := mustOutputWriter("{{ .Filename }}\n", true)
 
 	// Get regular expressions by their names and compile them.
 	res := viper.GetStringSlice("default_Regexps")
@@ -86,11 +92,10 @@ func matchFunc(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 	fmt.Fprintf(os.Stderr, "Using metadata parsers: %v\n", metadataParsers)
-	outputTmplSrc := viper.GetString("output_template")
 	var err error
-	outputTmpl, err = template.New("filename").Funcs(template.FuncMap{"ToUpper": strings.ToUpper, "join": strings.Join, "escape": escape}).Parse(outputTmplSrc)
+	outputTmpl, err = loadOutputTemplate()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
@@ -102,23 +107,27 @@ func matchFunc(cmd *cobra.Command, args []string) {
 	defer library.Close()
 
 	for _, path := range args {
-		if err := searchDupes(path, recursive, library); err != nil {
+		if err := searchDupes(path, recursive, library, out); err != nil {
 			fmt.Fprintf(os.Stderr, "Cannot search books from %s: %s; skipping\n", path, err)
 			continue
 		}
 	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing results: %s\n", err)
+		os.Exit(1)
+	}
 }
 
-// searchDupes searches for one or more duplicate books.
+// searchDupes searches for one or more duplicate books, and writes each one found to out.
 // root may be either a file or directory.
-func searchDupes(root string, recursive bool, library *books.Library) error {
+func searchDupes(root string, recursive bool, library *books.Library, out *outputWriter) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
 		if !info.IsDir() {
-			if err := searchDupe(path, library); err != nil {
+			if err := searchDupe(path, library, out); err != nil {
 				fmt.Fprintf(os.Stderr, "Cannot search book from %s: %s; skipping\n", path, err)
 			}
 			return nil
@@ -132,8 +141,8 @@ func searchDupes(root string, recursive bool, library *books.Library) error {
 	})
 }
 
-// searchDupe searches for a single duplicate book.
-func searchDupe(filename string, library *books.Library) error {
+// searchDupe searches for a single duplicate book, and writes it to out if found.
+func searchDupe(filename string, library *books.Library, out *outputWriter) error {
 	fi, err := os.Stat(filename)
 	if err != nil {
 		return errors.Wrap(err, "Get file info for book")
@@ -162,7 +171,7 @@ func searchDupe(filename string, library *books.Library) error {
 	if err != nil {
 		return errors.Wrap(err, "Calculate output filename for book")
 	}
-	s = truncateFilename(s)
+	s = books.TruncateFilename(s)
 	newFilename := books.GetUniqueName(filepath.Join(booksRoot, s))
 	bf.CurrentFilename, err = filepath.Rel(booksRoot, newFilename)
 	if err != nil {
@@ -176,12 +185,13 @@ func searchDupe(filename string, library *books.Library) error {
 		return errors.Wrap(err, "Search for duplicate book")
 	}
 	if found {
-		bks, _ := library.GetBooksByID([]int64{id})
-		bk := bks[0]
-		_ = bk
-		fmt.Println(filename)
-		//fmt.Printf("%s", booksRoot+"/"+bk.Files[0].CurrentFilename)
-		//fmt.Println("\n")
+		bks, err := library.GetBooksByID([]int64{id})
+		if err != nil || len(bks) == 0 {
+			return errors.Wrap(err, "Get duplicate book")
+		}
+		if err := out.Write(matchResult{filename, bks[0]}); err != nil {
+			return errors.Wrap(err, "Write duplicate book")
+		}
 	}
 
 	return nil
@@ -0,0 +1,77 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+var migrateDryRun bool
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the library to the latest schema",
+	Long: `Apply any pending schema migrations to the library.
+
+The library file is backed up next to itself before any migrations are applied.
+Use --dry-run to list the pending migrations without changing anything.
+
+Other commands also migrate the library automatically when they open it.`,
+	Run: migrateRun,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().BoolVarP(&migrateDryRun, "dry-run", "n", false, "List pending migrations without applying them")
+}
+
+func migrateRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibraryWithoutMigrating(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	version, err := lib.SchemaVersion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get schema version: %s\n", err)
+		os.Exit(1)
+	}
+	pending, err := lib.PendingMigrations()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get pending migrations: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Schema version: %d (latest: %d)\n", version, books.LatestSchemaVersion())
+	if len(pending) == 0 {
+		fmt.Println("The library is up to date.")
+		return
+	}
+	for i, m := range pending {
+		fmt.Printf("%d: %s\n", version+i+1, m.Description)
+	}
+	if migrateDryRun {
+		return
+	}
+
+	backup, err := lib.Backup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot back up library: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Backed up library to %s\n", backup)
+	if err := lib.Migrate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error migrating library: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Migrated library to schema version %d\n", books.LatestSchemaVersion())
+}
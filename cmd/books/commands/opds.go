@@ -0,0 +1,442 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// MIME types used by OPDS catalogs.
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	openSearchType      = "application/opensearchdescription+xml"
+)
+
+// opdsRecentBooks is the number of books shown in the recently added feed.
+const opdsRecentBooks = 50
+
+// bookMIMETypes maps file extensions to the MIME types e-reader apps expect.
+// Extensions not listed here are looked up with mime.TypeByExtension.
+var bookMIMETypes = map[string]string{
+	"azw":  "application/vnd.amazon.ebook",
+	"azw3": "application/x-mobi8-ebook",
+	"cbr":  "application/x-cbr",
+	"cbz":  "application/x-cbz",
+	"djvu": "image/vnd.djvu",
+	"doc":  "application/msword",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"epub": "application/epub+zip",
+	"fb2":  "application/x-fictionbook+xml",
+	"htm":  "text/html",
+	"html": "text/html",
+	"lit":  "application/x-ms-reader",
+	"mobi": "application/x-mobipocket-ebook",
+	"pdf":  "application/pdf",
+	"rtf":  "application/rtf",
+	"txt":  "text/plain",
+}
+
+// opdsFeed is an Atom feed, which is either a navigation feed linking to other feeds, or an acquisition feed listing books.
+type opdsFeed struct {
+	XMLName         xml.Name    `xml:"feed"`
+	Xmlns           string      `xml:"xmlns,attr"`
+	XmlnsOpenSearch string      `xml:"xmlns:opensearch,attr"`
+	ID              string      `xml:"id"`
+	Title           string      `xml:"title"`
+	Updated         string      `xml:"updated"`
+	Links           []opdsLink  `xml:"link"`
+	ItemsPerPage    int         `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex      int         `xml:"opensearch:startIndex,omitempty"`
+	Entries         []opdsEntry `xml:"entry"`
+}
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type opdsEntry struct {
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Authors []opdsAuthor `xml:"author"`
+	Content *opdsContent `xml:"content"`
+	Links   []opdsLink   `xml:"link"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type opdsContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// openSearchDescription describes how to search the catalog.
+type openSearchDescription struct {
+	XMLName     xml.Name        `xml:"OpenSearchDescription"`
+	Xmlns       string          `xml:"xmlns,attr"`
+	ShortName   string          `xml:"ShortName"`
+	Description string          `xml:"Description"`
+	URLs        []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// registerOPDS adds an OPDS 1.2 catalog to r, under /opds/.
+func registerOPDS(r *mux.Router, h *libHandler) {
+	r.HandleFunc("/opds/", opdsRootHandler)
+	r.HandleFunc("/opds/opensearch.xml", openSearchHandler)
+	r.HandleFunc("/opds/authors", h.opdsAuthorsHandler)
+	r.HandleFunc("/opds/authors/{id:\\d+}", h.opdsAuthorHandler)
+	r.HandleFunc("/opds/series", h.opdsSeriesHandler)
+	r.HandleFunc("/opds/series/books", h.opdsSeriesBooksHandler)
+	r.HandleFunc("/opds/shelves", h.opdsShelvesHandler)
+	r.HandleFunc("/opds/shelves/books", h.opdsShelfBooksHandler)
+	r.HandleFunc("/opds/recent", h.opdsRecentHandler)
+	r.HandleFunc("/opds/search", h.opdsSearchHandler)
+}
+
+// newOPDSFeed returns a feed with the links every feed in the catalog has.
+// self is the feed's own URL, and kind is its MIME type.
+func newOPDSFeed(id, title, self, kind string) *opdsFeed {
+	return &opdsFeed{
+		Xmlns:           "http://www.w3.org/2005/Atom",
+		XmlnsOpenSearch: "http://a9.com/-/spec/opensearch/1.1/",
+		ID:              "urn:books:opds:" + id,
+		Title:           title,
+		Updated:         opdsNow(),
+		Links: []opdsLink{
+			{Rel: "self", Href: self, Type: kind},
+			{Rel: "start", Href: "/opds/", Type: opdsNavigationType},
+			{Rel: "search", Href: "/opds/opensearch.xml", Type: openSearchType},
+		},
+	}
+}
+
+func opdsRootHandler(w http.ResponseWriter, r *http.Request) {
+	feed := newOPDSFeed("root", "Books", "/opds/", opdsNavigationType)
+	for _, nav := range []struct{ id, title, href, kind, content string }{
+		{"authors", "By author", "/opds/authors", opdsNavigationType, "Browse books by author"},
+		{"series", "By series", "/opds/series", opdsNavigationType, "Browse books by series"},
+		{"shelves", "Shelves", "/opds/shelves", opdsNavigationType, "Browse reading lists and other shelves"},
+		{"recent", "Recently added", "/opds/recent", opdsAcquisitionType, "The most recently added books"},
+	} {
+		feed.Entries = append(feed.Entries, opdsNavigationEntry(nav.id, nav.title, nav.href, nav.kind, nav.content))
+	}
+	writeOPDS(w, feed)
+}
+
+func openSearchHandler(w http.ResponseWriter, r *http.Request) {
+	desc := openSearchDescription{
+		Xmlns:       "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:   "Books",
+		Description: "Search the library",
+		URLs:        []openSearchURL{{Type: opdsAcquisitionType, Template: "/opds/search?q={searchTerms}"}},
+	}
+	writeXML(w, openSearchType, desc)
+}
+
+func (h *libHandler) opdsAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	authors, err := h.lib.GetAuthors()
+	if err != nil {
+		log.Printf("Error getting authors: %s", err)
+		http.Error(w, "Error getting authors", http.StatusInternalServerError)
+		return
+	}
+	feed := newOPDSFeed("authors", "Authors", "/opds/authors", opdsNavigationType)
+	for _, a := range authors {
+		feed.Entries = append(feed.Entries, opdsNavigationEntry("author:"+strconv.FormatInt(a.ID, 10), a.Name,
+			"/opds/authors/"+strconv.FormatInt(a.ID, 10), opdsAcquisitionType, booksCount(a.Books)))
+	}
+	opdsPage(feed, r, "/opds/authors?")
+	writeOPDS(w, feed)
+}
+
+func (h *libHandler) opdsAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	author, ok, err := h.lib.GetAuthor(id)
+	if err != nil {
+		log.Printf("Error getting author %d: %s", id, err)
+		http.Error(w, "Error getting author", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	found, err := h.lib.GetBooksByAuthor(id)
+	if err != nil {
+		log.Printf("Error getting books by author %d: %s", id, err)
+		http.Error(w, "Error getting books", http.StatusInternalServerError)
+		return
+	}
+	self := "/opds/authors/" + strconv.FormatInt(id, 10)
+	feed := newOPDSFeed("author:"+strconv.FormatInt(id, 10), author.Name, self, opdsAcquisitionType)
+	addBookEntries(feed, found)
+	opdsPage(feed, r, self+"?")
+	writeOPDS(w, feed)
+}
+
+func (h *libHandler) opdsSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	series, err := h.lib.GetSeries()
+	if err != nil {
+		log.Printf("Error getting series: %s", err)
+		http.Error(w, "Error getting series", http.StatusInternalServerError)
+		return
+	}
+	feed := newOPDSFeed("series", "Series", "/opds/series", opdsNavigationType)
+	for _, s := range series {
+		feed.Entries = append(feed.Entries, opdsNavigationEntry("series:"+url.QueryEscape(s.Name), s.Name,
+			"/opds/series/books?name="+url.QueryEscape(s.Name), opdsAcquisitionType, booksCount(s.Books)))
+	}
+	opdsPage(feed, r, "/opds/series?")
+	writeOPDS(w, feed)
+}
+
+func (h *libHandler) opdsSeriesBooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	found, err := h.lib.GetBooksInSeries(name)
+	if err != nil {
+		log.Printf("Error getting books in series %s: %s", name, err)
+		http.Error(w, "Error getting books", http.StatusInternalServerError)
+		return
+	}
+	if len(found) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	self := "/opds/series/books?name=" + url.QueryEscape(name)
+	feed := newOPDSFeed("series:"+url.QueryEscape(name), name, self, opdsAcquisitionType)
+	addBookEntries(feed, found)
+	opdsPage(feed, r, self+"&")
+	writeOPDS(w, feed)
+}
+
+func (h *libHandler) opdsShelvesHandler(w http.ResponseWriter, r *http.Request) {
+	shelves, err := h.lib.GetShelves()
+	if err != nil {
+		log.Printf("Error getting shelves: %s", err)
+		http.Error(w, "Error getting shelves", http.StatusInternalServerError)
+		return
+	}
+	feed := newOPDSFeed("shelves", "Shelves", "/opds/shelves", opdsNavigationType)
+	for _, s := range shelves {
+		feed.Entries = append(feed.Entries, opdsNavigationEntry("shelf:"+strconv.FormatInt(s.ID, 10), s.Name,
+			"/opds/shelves/books?name="+url.QueryEscape(s.Name), opdsAcquisitionType, booksCount(s.Books)))
+	}
+	opdsPage(feed, r, "/opds/shelves?")
+	writeOPDS(w, feed)
+}
+
+// opdsShelfBooksHandler lists the books on a shelf, in the order they're arranged in.
+func (h *libHandler) opdsShelfBooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	shelf, found, err := h.lib.GetShelf(name)
+	if err != nil {
+		log.Printf("Error getting shelf %s: %s", name, err)
+		http.Error(w, "Error getting books", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	self := "/opds/shelves/books?name=" + url.QueryEscape(shelf.Name)
+	feed := newOPDSFeed("shelf:"+strconv.FormatInt(shelf.ID, 10), shelf.Name, self, opdsAcquisitionType)
+	addBookEntries(feed, shelf.Books)
+	opdsPage(feed, r, self+"&")
+	writeOPDS(w, feed)
+}
+
+func (h *libHandler) opdsRecentHandler(w http.ResponseWriter, r *http.Request) {
+	found, err := h.lib.GetRecentBooks(opdsRecentBooks)
+	if err != nil {
+		log.Printf("Error getting recent books: %s", err)
+		http.Error(w, "Error getting books", http.StatusInternalServerError)
+		return
+	}
+	feed := newOPDSFeed("recent", "Recently added", "/opds/recent", opdsAcquisitionType)
+	addBookEntries(feed, found)
+	opdsPage(feed, r, "/opds/recent?")
+	writeOPDS(w, feed)
+}
+
+func (h *libHandler) opdsSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	page := opdsPageNumber(r)
+	offset := (page - 1) * itemsPerPage
+	found, moreResults, _, err := h.lib.SearchPaged(query, offset, itemsPerPage, 1)
+	if err != nil {
+		if qe, ok := err.(books.QueryError); ok {
+			http.Error(w, qe.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error searching for %s: %s", query, err)
+		http.Error(w, "Error searching", http.StatusInternalServerError)
+		return
+	}
+
+	self := "/opds/search?q=" + url.QueryEscape(query)
+	feed := newOPDSFeed("search:"+url.QueryEscape(query), "Search results for "+query, self, opdsAcquisitionType)
+	addBookEntries(feed, found)
+	feed.ItemsPerPage = itemsPerPage
+	feed.StartIndex = offset + 1
+	if page > 1 {
+		feed.Links = append(feed.Links, opdsLink{Rel: "previous", Href: self + "&page=" + strconv.Itoa(page-1), Type: opdsAcquisitionType})
+	}
+	if moreResults > 0 {
+		feed.Links = append(feed.Links, opdsLink{Rel: "next", Href: self + "&page=" + strconv.Itoa(page+1), Type: opdsAcquisitionType})
+	}
+	writeOPDS(w, feed)
+}
+
+// opdsPageNumber returns the page requested with the page query parameter, starting from 1.
+func opdsPageNumber(r *http.Request) int {
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page >= 1 {
+		return page
+	}
+	return 1
+}
+
+// opdsPage limits a feed's entries to the page requested in r, and adds links to the previous and next pages.
+// prefix is the URL of the feed, ending in ? or &, so that a page parameter can be added.
+func opdsPage(feed *opdsFeed, r *http.Request, prefix string) {
+	page := opdsPageNumber(r)
+	kind := feed.Links[0].Type
+	start := (page - 1) * itemsPerPage
+	if start > len(feed.Entries) {
+		start = len(feed.Entries)
+	}
+	end := start + itemsPerPage
+	if end >= len(feed.Entries) {
+		end = len(feed.Entries)
+	} else {
+		feed.Links = append(feed.Links, opdsLink{Rel: "next", Href: prefix + "page=" + strconv.Itoa(page+1), Type: kind})
+	}
+	if page > 1 {
+		feed.Links = append(feed.Links, opdsLink{Rel: "previous", Href: prefix + "page=" + strconv.Itoa(page-1), Type: kind})
+	}
+	feed.Entries = feed.Entries[start:end]
+	feed.ItemsPerPage = itemsPerPage
+	feed.StartIndex = start + 1
+}
+
+// opdsNavigationEntry returns an entry linking to another feed.
+func opdsNavigationEntry(id, title, href, kind, content string) opdsEntry {
+	return opdsEntry{
+		ID:      "urn:books:opds:" + id,
+		Title:   title,
+		Updated: opdsNow(),
+		Content: &opdsContent{Type: "text", Text: content},
+		Links:   []opdsLink{{Rel: "subsection", Href: href, Type: kind}},
+	}
+}
+
+// addBookEntries adds an entry for each book to feed, with an acquisition link for each of its files.
+func addBookEntries(feed *opdsFeed, bks []books.Book) {
+	for _, book := range bks {
+		entry := opdsEntry{
+			ID:      "urn:books:book:" + strconv.FormatInt(book.ID, 10),
+			Title:   book.Title,
+			Updated: opdsNow(),
+			Links:   []opdsLink{{Rel: "alternate", Href: "/book/" + strconv.FormatInt(book.ID, 10), Type: "text/html"}},
+		}
+		for _, a := range book.Authors {
+			entry.Authors = append(entry.Authors, opdsAuthor{Name: a})
+		}
+		text := ""
+		if book.Series != "" {
+			text = "Series: " + book.Series
+			if book.SeriesIndex != 0 {
+				text += " #" + strconv.FormatFloat(book.SeriesIndex, 'f', -1, 64)
+			}
+		}
+		if book.Description != "" {
+			if text != "" {
+				text += "\n\n"
+			}
+			text += book.Description
+		}
+		if text != "" {
+			entry.Content = &opdsContent{Type: "text", Text: text}
+		}
+		for _, bf := range book.Files {
+			title := strings.ToUpper(bf.Extension)
+			if len(bf.Tags) > 0 {
+				title += " (" + strings.Join(bf.Tags, ", ") + ")"
+			}
+			entry.Links = append(entry.Links, opdsLink{
+				Rel:   "http://opds-spec.org/acquisition",
+				Href:  "/download/" + strconv.FormatInt(bf.ID, 10),
+				Type:  bookMIMEType(bf.Extension),
+				Title: title,
+			})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+}
+
+// bookMIMEType returns the MIME type of a file with the given extension.
+func bookMIMEType(ext string) string {
+	ext = strings.ToLower(ext)
+	if t, ok := bookMIMETypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension("." + ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func booksCount(n int) string {
+	if n == 1 {
+		return "1 book"
+	}
+	return fmt.Sprintf("%d books", n)
+}
+
+// opdsNow returns the current time in the format used by Atom feeds.
+func opdsNow() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func writeOPDS(w http.ResponseWriter, feed *opdsFeed) {
+	writeXML(w, feed.Links[0].Type, feed)
+}
+
+// writeXML writes v to w as an XML document with the given content type.
+func writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType+";charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Error writing XML: %s", err)
+	}
+}
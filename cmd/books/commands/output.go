@@ -0,0 +1,106 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// outputFormat is set by the --format flag.
+var outputFormat string
+
+// An outputWriter prints the results of a command in the format chosen with --format:
+// text, the command's own format; json, a single JSON document; jsonl, one JSON object per line;
+// or template=TEMPLATE, a Go template executed for each result.
+type outputWriter struct {
+	w      io.Writer
+	format string
+	tmpl   *template.Template
+	list   bool
+	items  []interface{}
+}
+
+// newOutputWriter returns an outputWriter which writes to stdout.
+// textTmplSrc is the template used for the text format, and is executed for each result; if it is empty, nothing is printed.
+// If list is true, the json format prints an array of every result, even if there is only one.
+func newOutputWriter(textTmplSrc string, list bool) (*outputWriter, error) {
+	o := &outputWriter{w: os.Stdout, format: outputFormat, list: list}
+	tmplSrc := textTmplSrc
+	switch {
+	case outputFormat == "text":
+	case outputFormat == "json", outputFormat == "jsonl":
+		return o, nil
+	case strings.HasPrefix(outputFormat, "template="):
+		o.format = "template"
+		tmplSrc = strings.TrimPrefix(outputFormat, "template=") + "\n"
+	default:
+		return nil, errors.Errorf("Unknown output format %s; use json, jsonl, text, or template=TEMPLATE", outputFormat)
+	}
+
+	if tmplSrc == "" {
+		return o, nil
+	}
+	tmpl, err := template.New("output").Funcs(funcMap).Option("missingkey=zero").Parse(tmplSrc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Parse output template")
+	}
+	o.tmpl = tmpl
+	return o, nil
+}
+
+// Write prints a single result.
+// In the json format, results are only printed once Close is called.
+func (o *outputWriter) Write(v interface{}) error {
+	switch o.format {
+	case "json":
+		o.items = append(o.items, v)
+		return nil
+	case "jsonl":
+		return json.NewEncoder(o.w).Encode(v)
+	}
+	if o.tmpl == nil {
+		return nil
+	}
+	return o.tmpl.Execute(o.w, v)
+}
+
+// Close prints any results that were waiting to be printed.
+func (o *outputWriter) Close() error {
+	if o.format != "json" {
+		return nil
+	}
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	if !o.list && len(o.items) == 1 {
+		return enc.Encode(o.items[0])
+	}
+	if o.items == nil {
+		o.items = []interface{}{}
+	}
+	return enc.Encode(o.items)
+}
+
+// textOutput returns true if results are being printed in the text format,
+// so commands can print any extra messages meant for people.
+func textOutput() bool {
+	return outputFormat == "text"
+}
+
+// mustOutputWriter returns an outputWriter as newOutputWriter does, exiting if the format is invalid.
+func mustOutputWriter(textTmplSrc string, list bool) *outputWriter {
+	o, err := newOutputWriter(textTmplSrc, list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	return o
+}
@@ -0,0 +1,151 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// readingTemplate defines the reading template, which prints a book's reading status, dates, rating and notes
+// for the text output of show and reading.
+const readingTemplate = `{{define "reading"}}{{if .Status}}Status: {{.Status.Description}}
+{{end}}{{with .Started}}Started: {{.Format "2006-01-02"}}
+{{end}}{{with .Finished}}Finished: {{.Format "2006-01-02"}}
+{{end}}{{if .Rating}}Rating: {{.Rating}}/5
+{{end}}{{if .Notes}}Notes: {{.Notes}}
+{{end}}{{end}}`
+
+// readingCmd represents the reading command
+var readingCmd = &cobra.Command{
+	Use:   "reading BOOK_ID",
+	Short: "Show or set the reading status, rating and notes of a book",
+	Long: `Show or set the reading status, start and finish dates, rating and notes of a book.
+
+With no flags, the book's current reading information is shown.
+Otherwise, only the given fields are changed. Pass none to clear a field.
+
+The status is one of want-to-read, reading, finished, or abandoned.
+Dates are in the form YYYY-MM-DD, and ratings are from 1 to 5.
+
+Examples:
+    books reading 12 --status reading --started 2018-09-28
+    books reading 12 --status finished --finished 2018-10-05 --rating 4 --notes "Better than the first one"
+    books reading 12 --rating none`,
+	Run: CPUProfile(readingRun),
+}
+
+func init() {
+	rootCmd.AddCommand(readingCmd)
+
+	readingCmd.Flags().StringP("status", "s", "", "Reading status: want-to-read, reading, finished, abandoned, or none")
+	readingCmd.Flags().String("started", "", "Date the book was started, as YYYY-MM-DD, or none")
+	readingCmd.Flags().String("finished", "", "Date the book was finished, as YYYY-MM-DD, or none")
+	readingCmd.Flags().StringP("rating", "r", "", "Rating from 1 to 5, or none")
+	readingCmd.Flags().StringP("notes", "n", "", "Notes about the book, or an empty string to clear them")
+}
+
+func readingRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "No book ID specified.")
+		os.Exit(1)
+	}
+	bookID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
+		os.Exit(1)
+	}
+	out := mustOutputWriter(`{{joinNaturally "and" .Authors}} - {{.Title}}
+{{template "reading" .Reading}}{{if .Reading.IsZero}}No reading information for this book.
+{{end}}`+readingTemplate, false)
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	bks, err := lib.GetBooksByID([]int64{bookID})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting book by ID: %s\n", err)
+		os.Exit(1)
+	}
+	if len(bks) == 0 {
+		fmt.Fprintln(os.Stderr, "Book not found.")
+		os.Exit(1)
+	}
+	book := bks[0]
+
+	r, changed, err := readingFromFlags(cmd, book.Reading)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if changed {
+		if err := lib.SetReading(book.ID, r); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting reading information: %s\n", err)
+			os.Exit(1)
+		}
+		if bks, err := lib.GetBooksByID([]int64{bookID}); err == nil && len(bks) == 1 {
+			book = bks[0]
+		}
+	}
+
+	if err := out.Write(book); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing book: %s\n", err)
+		os.Exit(1)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing book: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// readingFromFlags applies the flags given to the reading command to r, and returns true if any were given.
+func readingFromFlags(cmd *cobra.Command, r books.Reading) (books.Reading, bool, error) {
+	flags := cmd.Flags()
+	changed := false
+	if flags.Changed("status") {
+		s, _ := flags.GetString("status")
+		status, err := books.ParseReadingStatus(s)
+		if err != nil {
+			return r, false, err
+		}
+		r.Status, changed = status, true
+	}
+	for _, f := range []struct {
+		name string
+		date **time.Time
+	}{{"started", &r.Started}, {"finished", &r.Finished}} {
+		if !flags.Changed(f.name) {
+			continue
+		}
+		s, _ := flags.GetString(f.name)
+		t, err := books.ParseReadingDate(s)
+		if err != nil {
+			return r, false, err
+		}
+		*f.date, changed = t, true
+	}
+	if flags.Changed("rating") {
+		s, _ := flags.GetString("rating")
+		rating, err := books.ParseRating(s)
+		if err != nil {
+			return r, false, err
+		}
+		r.Rating, changed = rating, true
+	}
+	if flags.Changed("notes") {
+		r.Notes, _ = flags.GetString("notes")
+		changed = true
+	}
+	return r, changed, nil
+}
@@ -0,0 +1,45 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// reindexCmd represents the reindex command
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the search index",
+	Long: `Drop and rebuild the full-text search index from the books, files, authors and tags in the library.
+
+This fixes searches that return books which no longer match, or miss books which should.`,
+	Run: CPUProfile(reindexRun),
+}
+
+func init() {
+	rootCmd.AddCommand(reindexCmd)
+}
+
+func reindexRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	err = lib.Reindex(func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\rIndexed %d of %d books", done, total)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rebuilding search index: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+var renameAll bool
+var renameDryRun bool
+
+// renameCmd represents the rename command
+var renameCmd = &cobra.Command{
+	Use:   "rename [BOOK_ID...]",
+	Short: "Rename files to match the output template",
+	Long: `Rename the files of the given books, or every book with --all,
+so that their names match the output template in the config file,
+or the template override set for each file in the library.
+
+Use --dry-run to list the files that would be renamed without changing anything.`,
+	Run: CPUProfile(renameRun),
+}
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+
+	renameCmd.Flags().BoolVarP(&renameAll, "all", "a", false, "Rename the files of every book in the library")
+	renameCmd.Flags().BoolVarP(&renameDryRun, "dry-run", "n", false, "List files that would be renamed without renaming them")
+}
+
+func renameRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 && !renameAll {
+		fmt.Fprintln(os.Stderr, "No book ID specified. Use --all to rename the files of every book.")
+		os.Exit(1)
+	}
+	ids, err := parseIDs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
+		os.Exit(1)
+	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if renameAll {
+		ids, err = lib.GetAllBookIDs()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get books: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	renamed, err := lib.RenameFiles(ids, tmpl, renameDryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming files: %s\n", err)
+		os.Exit(1)
+	}
+	for _, r := range renamed {
+		fmt.Printf("%s -> %s\n", r.OldName, r.NewName)
+	}
+	if renameDryRun {
+		fmt.Printf("%d files would be renamed\n", len(renamed))
+	} else {
+		fmt.Printf("%d files renamed\n", len(renamed))
+	}
+}
+
+// renameBookFiles renames the files of books whose metadata was changed, so that their names match the output template.
+func renameBookFiles(lib *books.Library, tmpl *template.Template, ids []int64) {
+	renamed, err := lib.RenameFiles(ids, tmpl, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming files: %s\n", err)
+		os.Exit(1)
+	}
+	for _, r := range renamed {
+		fmt.Printf("%s -> %s\n", r.OldName, r.NewName)
+	}
+}
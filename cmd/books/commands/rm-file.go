@@ -0,0 +1,62 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// rmFileCmd represents the rm-file command
+var rmFileCmd = &cobra.Command{
+	Use:   "rm-file FILE_ID...",
+	Short: "Remove files from the library",
+	Long: `Remove one or more files from the library.
+If a book has no files left, it is removed as well.
+
+By default, the files are moved into the trash directory (trash_dir in the config file,
+or trash in the config directory), keeping their paths relative to the books root.
+Use --delete to delete them from the books root instead.`,
+	Run: CPUProfile(rmFileRun),
+}
+
+func init() {
+	rootCmd.AddCommand(rmFileCmd)
+
+	rmFileCmd.Flags().BoolVarP(&deleteFiles, "delete", "d", false, "Delete files instead of moving them to the trash directory")
+}
+
+func rmFileRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "No file ID specified.")
+		os.Exit(1)
+	}
+	ids, err := parseIDs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "File ID must be a number.")
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	failed := false
+	for _, id := range ids {
+		if err := lib.DeleteFile(id, trashDir()); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot remove file %d: %s\n", id, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
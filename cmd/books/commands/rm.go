@@ -0,0 +1,86 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+var deleteFiles bool
+
+// rmCmd represents the rm command
+var rmCmd = &cobra.Command{
+	Use:   "rm BOOK_ID...",
+	Short: "Remove books from the library",
+	Long: `Remove one or more books, and all of their files, from the library.
+
+By default, the files are moved into the trash directory (trash_dir in the config file,
+or trash in the config directory), keeping their paths relative to the books root.
+Use --delete to delete them from the books root instead.`,
+	Run: CPUProfile(rmRun),
+}
+
+func init() {
+	rootCmd.AddCommand(rmCmd)
+
+	rmCmd.Flags().BoolVarP(&deleteFiles, "delete", "d", false, "Delete files instead of moving them to the trash directory")
+}
+
+func rmRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "No book ID specified.")
+		os.Exit(1)
+	}
+	ids, err := parseIDs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	failed := false
+	for _, id := range ids {
+		if err := lib.DeleteBook(id, trashDir()); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot remove book %d: %s\n", id, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// trashDir returns the directory removed files should be moved into, or an empty string if they should be deleted.
+func trashDir() string {
+	if deleteFiles {
+		return ""
+	}
+	return viper.GetString("trash_dir")
+}
+
+// parseIDs parses a list of book or file IDs given on the command line.
+func parseIDs(args []string) ([]int64, error) {
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
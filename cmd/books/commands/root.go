@@ -28,6 +28,7 @@ var funcMap = template.FuncMap{
 		return i + 1
 	},
 	"joinNaturally": joinNaturally,
+	"join":          strings.Join,
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -54,6 +55,7 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgDir, "config", "", "config directory (default is $HOME/.config/books)")
 	rootCmd.PersistentFlags().StringVar(&cpuProfile, "cpuprofile", "", "CPU profile filename")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "format", "text", "Output format: text, json, jsonl, or template=TEMPLATE")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -84,6 +86,7 @@ func initConfig() {
 
 	viper.SetDefault("root", path.Join(home, "books"))
 	booksRoot = viper.GetString("root")
+	viper.SetDefault("trash_dir", path.Join(cfgDir, "trash"))
 }
 
 // CPUProfile wraps a cobra command for CPU profiling.
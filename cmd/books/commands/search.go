@@ -8,59 +8,120 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"text/template"
 
 	"github.com/tspivey/books"
 
 	"github.com/spf13/cobra"
 )
 
+var searchSaved string
+
 // searchCmd represents the search command
 var searchCmd = &cobra.Command{
 	Use:   "search TERMS",
 	Short: "Search the library",
 	Long: `Search the library.
 By default, all fields are searched. This can be overridden with field:value.
-Supported fields: author, series, title, tags, extension.
+Supported fields: author, series, title, tags, extension, filename, source, publisher, subject, description.
+Values containing spaces can be quoted, or have their spaces replaced with +.
+A trailing * matches any word starting with the value.
+
+Results can also be filtered by:
+    ext:epub          books with a file of this extension
+    tag:ocr           books with a file tagged with this tag
+    added:2018-09     books added in this year, month, or day
+    size:>5MB         books with a file of this size (B, KB, MB, or GB)
+    status:reading    books with this reading status (want-to-read, reading, finished, abandoned, or none)
+    rating:>=4        books rated from 1 to 5
+    started:2018      books started in this year, month, or day
+    finished:2018-09  books finished in this year, month, or day
+    isbn:0765326353   books with this ISBN-10 or ISBN-13
+    asin:B003P2WO5E   books with this Amazon ASIN
+    language:en       books in this language, including regional variants such as en-US
+    published:2010    books published in this year, month, or day
+    shelf:favorites   books on this shelf
+Other identifiers can be found with identifier:type:value, or identifier:value to match any type.
+added, size, rating, started, finished and published can be compared with <, <=, >, >= or =, as in added:>=2018.
+Custom fields declared in the config file can be searched with name:value; see books fields.
+
+Terms can be negated with - or NOT, and joined with OR.
+Results are ordered by relevance, unless sort:author, sort:title, sort:series, sort:added,
+sort:rating, sort:finished or sort:published is given.
+Prefix the sort field with - to reverse the order, as in sort:-added.
 
 Examples:
     Wizard's First Rule
-    series:Sword+of+Truth
-    author:Terry+Goodkind title:Phantom`,
+    series:"Sword of Truth"
+    author:Terry+Goodkind title:Phantom
+    author:"Terry Goodkind" -tag:ocr ext:epub added:>2018-01-01 sort:title
+
+Searches saved with books searches save can be run with --saved NAME.
+Any terms given are added to the saved query, narrowing its results.`,
 	Run: CPUProfile(searchRun),
 }
 
 func searchRun(cmd *cobra.Command, args []string) {
 	terms := strings.Join(args, " ")
+	out := mustOutputWriter(`{{joinNaturally "and" .Authors}} - {{.Title -}}
+{{if .Series}} [{{.Series}}{{if .SeriesIndex}} #{{.SeriesIndex}}{{end}}]{{end }} ({{ .ID }})
+`, true)
 	lib, err := books.OpenLibrary(libraryFile, booksRoot)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
 	}
-
-	books, err := lib.Search(terms)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error while searching for books: %s\n", err)
+	if err := loadCustomFields(lib); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading custom fields: %s\n", err)
 		os.Exit(1)
 	}
-	resultTmplSrc := `{{range $i, $v := . -}}
-{{joinNaturally "and" $v.Authors}} - {{$v.Title -}}
-{{if $v.Series}} [{{$v.Series}}]{{end }} ({{ $v.ID }})
-{{end}}`
 
-	tmpl, err := template.New("search_result").Funcs(funcMap).Parse(resultTmplSrc)
+	var found []books.Book
+	var suggestions []string
+	if searchSaved != "" {
+		var s books.SavedSearch
+		var ok bool
+		s, ok, err = lib.GetSavedSearch(searchSaved)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting saved search: %s\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Saved search %s not found.\n", searchSaved)
+			os.Exit(1)
+		}
+		if terms != "" {
+			s.Query += " " + terms
+		}
+		found, _, err = lib.SearchSaved(s, 0, 0, 0)
+	} else {
+		found, suggestions, err = lib.Search(terms)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing template: %s\n", err)
+		fmt.Fprintf(os.Stderr, "Error while searching for books: %s\n", err)
 		os.Exit(1)
 	}
+	if len(suggestions) > 0 {
+		fmt.Fprintf(os.Stderr, "No results for %s. Showing results for %s.\n", terms, suggestions[0])
+		if len(suggestions) > 1 {
+			fmt.Fprintf(os.Stderr, "Did you mean: %s\n", strings.Join(suggestions[1:], ", "))
+		}
+	}
 
-	err = tmpl.Execute(os.Stdout, books)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error executing template: %s\n", err)
+	for _, book := range found {
+		book.Snippet = books.HighlightHTML(book.Snippet)
+		if err := out.Write(book); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing results: %s\n", err)
 		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().StringVarP(&searchSaved, "saved", "s", "", "Run the saved search with this name")
 }
@@ -0,0 +1,51 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// searchesListCmd represents the searches list command
+var searchesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved searches",
+	Long:  `List every saved search, with its query.`,
+	Run:   CPUProfile(searchesListRun),
+}
+
+func init() {
+	searchesCmd.AddCommand(searchesListCmd)
+}
+
+func searchesListRun(cmd *cobra.Command, args []string) {
+	out := mustOutputWriter("{{.Name}}: {{.Query}}\n", true)
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	searches, err := lib.GetSavedSearches()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting saved searches: %s\n", err)
+		os.Exit(1)
+	}
+	for _, s := range searches {
+		if err := out.Write(s); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing saved search: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing saved search: %s\n", err)
+		os.Exit(1)
+	}
+}
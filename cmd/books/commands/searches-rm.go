@@ -0,0 +1,44 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// searchesRmCmd represents the searches rm command
+var searchesRmCmd = &cobra.Command{
+	Use:   "rm NAME",
+	Short: "Delete a saved search",
+	Run:   CPUProfile(searchesRmRun),
+}
+
+func init() {
+	searchesCmd.AddCommand(searchesRmCmd)
+}
+
+func searchesRmRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "No saved search name specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.DeleteSavedSearch(strings.Join(args, " ")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error deleting saved search: %s\n", err)
+		os.Exit(1)
+	}
+}
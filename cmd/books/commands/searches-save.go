@@ -0,0 +1,49 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// searchesSaveCmd represents the searches save command
+var searchesSaveCmd = &cobra.Command{
+	Use:   "save NAME TERMS",
+	Short: "Save a search",
+	Long: `Save a search query under a name, replacing the query of any saved search with that name.
+The query is written as for books search. Quote the name if it contains spaces.
+
+Example:
+    books searches save "New epubs" ext:epub added:>2018-01-01 sort:-added`,
+	Run: CPUProfile(searchesSaveRun),
+}
+
+func init() {
+	searchesCmd.AddCommand(searchesSaveCmd)
+}
+
+func searchesSaveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Specify a name and the terms to search for.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.SaveSearch(args[0], strings.Join(args[1:], " ")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving search: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// searchesCmd represents the searches command
+var searchesCmd = &cobra.Command{
+	Use:   "searches",
+	Short: "Manage saved searches",
+	Long: `Save search queries under a name, so they can be run again.
+
+A saved search stores only its query, so its results are found again every time it's run,
+and include books added since it was saved. Saved search names are matched ignoring case.
+Run a saved search with books search --saved NAME.
+The web interface lists saved searches in its sidebar, and shows the results of each one at /saved/NAME.`,
+}
+
+func init() {
+	rootCmd.AddCommand(searchesCmd)
+}
@@ -0,0 +1,82 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// seriesCmd represents the series command
+var seriesCmd = &cobra.Command{
+	Use:   "series [NAME]",
+	Short: "List series, or the volumes in a series",
+	Long: `List every series in the library, with the number of books in each.
+
+If a series name is given, the books in that series are listed in order of their series index,
+along with any volumes missing from the library. Books without a series index are listed last.`,
+	Run: CPUProfile(seriesRun),
+}
+
+func init() {
+	rootCmd.AddCommand(seriesCmd)
+}
+
+func seriesRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if len(args) == 0 {
+		out := mustOutputWriter("{{.Name}} ({{.Books}})\n", true)
+		series, err := lib.GetSeries()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting series: %s\n", err)
+			os.Exit(1)
+		}
+		for _, s := range series {
+			if err := out.Write(s); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing series: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := out.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing series: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	out := mustOutputWriter(`{{.Name}}
+{{range .Volumes}}{{if .Book}}{{if .Index}}#{{.Index}}{{else}}Unnumbered{{end -}}
+: {{joinNaturally "and" .Book.Authors}} - {{.Book.Title}} ({{.Book.ID}})
+{{else}}#{{.Index}}: missing
+{{end}}{{end}}`, false)
+	name := strings.Join(args, " ")
+	series, found, err := lib.GetSeriesVolumes(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting series: %s\n", err)
+		os.Exit(1)
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "No books found in series %s.\n", name)
+		os.Exit(1)
+	}
+	if err := out.Write(series); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing series: %s\n", err)
+		os.Exit(1)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing series: %s\n", err)
+		os.Exit(1)
+	}
+}
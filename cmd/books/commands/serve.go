@@ -17,6 +17,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	texttemplate "text/template"
 	"time"
 
 	auth "github.com/abbot/go-http-auth"
@@ -62,6 +63,17 @@ type libHandler struct {
 	convertingMtx sync.Mutex
 	converting    map[int64]error // Holds book conversion status
 	fileCh        chan *books.BookFile
+	// authenticator is set when an htpasswd file is in use, and only then is editing allowed.
+	authenticator *auth.BasicAuth
+	outputTmpl    *texttemplate.Template
+	// canUpload is set if the metadata parsers could be loaded, so uploaded books can be imported.
+	canUpload bool
+	importMtx sync.Mutex
+}
+
+// indexPage is the data for the index template.
+type indexPage struct {
+	CanUpload bool
 }
 
 func runServer(cmd *cobra.Command, args []string) {
@@ -70,6 +82,16 @@ func runServer(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "Error creating cache directory: %s\n", err)
 		os.Exit(1)
 	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening library: %s\n", err)
+		os.Exit(1)
+	}
+	if err := loadCustomFields(lib); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading custom fields: %s\n", err)
+		os.Exit(1)
+	}
+
 	templatesDir := path.Join(cfgDir, "templates")
 	htmlFuncMap := template.FuncMap{
 		"joinNaturally": joinNaturally,
@@ -78,11 +100,25 @@ func runServer(cmd *cobra.Command, args []string) {
 		"base":          path.Base,
 		"pathEscape":    url.PathEscape,
 		"changeExt":     changeExt,
+		"highlight":     highlight,
+		"join":          strings.Join,
+		"searchQuery":   searchQuery,
+		// savedSearches lists the saved searches in the sidebar of every page.
+		"savedSearches": lib.GetSavedSearches,
 	}
 	templates = template.Must(template.New("template").Funcs(htmlFuncMap).ParseGlob(path.Join(templatesDir, "*.html")))
-	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	outputTmpl, err = loadOutputTemplate()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening library: %s\n", err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if err := initCSRFKey(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating CSRF key: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := initUploads(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating uploads directory: %s\n", err)
 		os.Exit(1)
 	}
 
@@ -91,6 +127,12 @@ func runServer(cmd *cobra.Command, args []string) {
 		lib:        lib,
 		fileCh:     make(chan *books.BookFile),
 		converting: make(map[int64]error),
+		outputTmpl: outputTmpl,
+	}
+	if err := loadMetadataParsers(); err != nil {
+		log.Printf("Uploading is disabled: %s", err)
+	} else {
+		lh.canUpload = true
 	}
 
 	numConversionWorkers := viper.GetInt("server.conversion_workers")
@@ -101,18 +143,37 @@ func runServer(cmd *cobra.Command, args []string) {
 
 	itemsPerPage = viper.GetInt("server.items_per_page")
 
-	r.HandleFunc("/", indexHandler)
+	r.HandleFunc("/", lh.indexHandler)
 	r.HandleFunc("/book/{id:\\d+}", lh.bookDetailsHandler)
+	r.HandleFunc("/book/{id:\\d+}/edit", lh.bookEditHandler).Methods("POST")
+	r.HandleFunc("/book/{id:\\d+}/merge", lh.bookMergeHandler).Methods("POST")
+	r.HandleFunc("/series/{name:.+}", lh.seriesHandler)
+	r.HandleFunc("/tags/", lh.tagsHandler)
+	r.HandleFunc("/shelves/", lh.shelvesHandler)
+	r.HandleFunc("/shelf/{name:.+}", lh.shelfHandler)
+	r.HandleFunc("/authors/", lh.authorsHandler)
+	r.HandleFunc("/author/{id:\\d+}", lh.authorHandler)
+	r.HandleFunc("/cover/{id:\\d+}", lh.coverHandler)
+	r.HandleFunc("/cover/{id:\\d+}/thumb", lh.coverHandler)
 	r.HandleFunc("/download/{id:\\d+}/{name:.+}", lh.downloadHandler)
 	r.HandleFunc("/download/{id:\\d+}", lh.downloadHandler)
 	r.HandleFunc("/search/", lh.searchHandler)
+	r.HandleFunc("/saved/{name:.+}", lh.savedSearchHandler)
+	r.HandleFunc("/upload", lh.uploadFormHandler).Methods("GET")
+	r.HandleFunc("/upload", lh.uploadHandler).Methods("POST")
+	r.HandleFunc("/upload/metadata", lh.uploadMetadataHandler).Methods("POST")
+	registerAPI(r, &lh)
+	registerOPDS(r, &lh)
 
 	secProvider := auth.HtpasswdFileProvider(htpasswdFile)
 	authHandler := auth.NewBasicAuthenticator("Basic Realm", secProvider)
 	handler := http.Handler(r)
 	if _, err := os.Stat(htpasswdFile); err == nil {
 		handler = auth.JustCheck(authHandler, handler.ServeHTTP)
+		lh.authenticator = authHandler
 		log.Printf("Using htpasswd file: %s\n", htpasswdFile)
+	} else {
+		log.Printf("No htpasswd file, so editing is disabled")
 	}
 
 	srv := &http.Server{
@@ -128,8 +189,43 @@ func runServer(cmd *cobra.Command, args []string) {
 	log.Fatal(srv.ListenAndServe())
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	render("index", w, nil)
+func (h *libHandler) indexHandler(w http.ResponseWriter, r *http.Request) {
+	render("index", w, indexPage{h.uploadingUser(r) != ""})
+}
+
+// coverHandler serves a book's cover, or its thumbnail if the path ends in /thumb.
+// Books without a cover return 404, so pages should only link to covers of books which have one.
+func (h *libHandler) coverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	bks, err := h.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting book %d: %s", id, err)
+		http.NotFound(w, r)
+		return
+	}
+	if len(bks) == 0 || bks[0].Cover == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	fn := h.lib.CoverFilename(bks[0].Cover)
+	etag := bks[0].Cover
+	if strings.HasSuffix(r.URL.Path, "/thumb") {
+		if fn, err = h.lib.ThumbnailFilename(bks[0].Cover); err != nil {
+			log.Printf("Error getting thumbnail for book %d: %s", id, err)
+			http.NotFound(w, r)
+			return
+		}
+		etag += "-thumb"
+	}
+	// Covers are named after their contents, so the name makes a strong ETag.
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Cache-Control", "max-age=86400")
+	http.ServeFile(w, r, fn)
 }
 
 func (h *libHandler) downloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -205,25 +301,121 @@ func (h *libHandler) downloadHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *libHandler) bookDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	bookID := mux.Vars(r)["id"]
-	id, err := strconv.Atoi(bookID)
+	book, ok := h.getBook(w, r)
+	if !ok {
+		return
+	}
+
+	details := bookDetails{Book: book}
+	if user := h.editingUser(r); user != "" {
+		details.CanEdit = true
+		details.CSRFToken = csrfToken(user, time.Now())
+		details.Statuses = books.ReadingStatuses
+		for i := 1; i <= books.MaxRating; i++ {
+			details.Ratings = append(details.Ratings, i)
+		}
+	}
+	render("book_details", w, details)
+}
+
+// seriesHandler lists the books in a series in order, along with the volumes missing from it.
+func (h *libHandler) seriesHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	series, found, err := h.lib.GetSeriesVolumes(name)
 	if err != nil {
-		http.NotFound(w, r)
+		log.Printf("Error getting series %s: %s", name, err)
+		render("error_page", w, errorPage{"Error getting series", "An error occurred while getting that series."})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		render("error_page", w, errorPage{"Series not found", "There are no books in that series."})
+		return
+	}
+	render("series", w, series)
+}
+
+// tagsHandler lists every tag, with the number of books which have it.
+func (h *libHandler) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.lib.GetTags()
+	if err != nil {
+		log.Printf("Error getting tags: %s", err)
+		render("error_page", w, errorPage{"Error getting tags", "An error occurred while getting the tags."})
+		return
+	}
+	render("tags", w, tags)
+}
+
+// authorsHandler lists every author, with the number of books by each.
+func (h *libHandler) authorsHandler(w http.ResponseWriter, r *http.Request) {
+	authors, err := h.lib.GetAuthors()
+	if err != nil {
+		log.Printf("Error getting authors: %s", err)
+		render("error_page", w, errorPage{"Error getting authors", "An error occurred while getting the authors."})
 		return
 	}
-	books, err := h.lib.GetBooksByID([]int64{int64(id)})
+	render("authors", w, authors)
+}
+
+// authorPage is the data for the author template.
+type authorPage struct {
+	books.Category
+	Groups []books.BookGroup
+}
+
+// authorHandler lists an author's books, grouped by series.
+func (h *libHandler) authorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		log.Printf("Error getting books by ID: %s", err)
 		http.NotFound(w, r)
 		return
 	}
-	if len(books) == 0 {
-		render("error_page", w, errorPage{"Book not found", "That book doesn't exist in the library."})
+	author, found, err := h.lib.GetAuthor(id)
+	if err != nil {
+		log.Printf("Error getting author %d: %s", id, err)
+		render("error_page", w, errorPage{"Error getting author", "An error occurred while getting that author."})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		render("error_page", w, errorPage{"Author not found", "That author doesn't exist in the library."})
+		return
+	}
+	bks, err := h.lib.GetBooksByAuthor(id)
+	if err != nil {
+		log.Printf("Error getting books by author %d: %s", id, err)
+		render("error_page", w, errorPage{"Error getting author", "An error occurred while getting that author's books."})
+		return
+	}
+	render("author", w, authorPage{author, books.GroupBySeries(bks)})
+}
+
+// shelvesHandler lists every shelf, with the number of books on it.
+func (h *libHandler) shelvesHandler(w http.ResponseWriter, r *http.Request) {
+	shelves, err := h.lib.GetShelves()
+	if err != nil {
+		log.Printf("Error getting shelves: %s", err)
+		render("error_page", w, errorPage{"Error getting shelves", "An error occurred while getting the shelves."})
 		return
 	}
-	book := books[0]
+	render("shelves", w, shelves)
+}
 
-	render("book_details", w, book)
+// shelfHandler lists the books on a shelf in order.
+func (h *libHandler) shelfHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	shelf, found, err := h.lib.GetShelf(name)
+	if err != nil {
+		log.Printf("Error getting shelf %s: %s", name, err)
+		render("error_page", w, errorPage{"Error getting shelf", "An error occurred while getting that shelf."})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		render("error_page", w, errorPage{"Shelf not found", "There is no shelf with that name."})
+		return
+	}
+	render("shelf", w, shelf)
 }
 
 type results struct {
@@ -233,6 +425,18 @@ type results struct {
 	Next       int
 	PageLinks  []int
 	Query      string
+	// Suggestions holds similar queries when Query had no results. Books holds the results of the first one.
+	Suggestions []string
+	// Saved is the name of the saved search the results are from, if any.
+	Saved string
+}
+
+// PageURL returns the URL of a page of the results.
+func (res results) PageURL(page int) string {
+	if res.Saved != "" {
+		return "/saved/" + url.PathEscape(res.Saved) + "?page=" + strconv.Itoa(page)
+	}
+	return "/search/?query=" + url.QueryEscape(res.Query) + "&page=" + strconv.Itoa(page)
 }
 
 type errorPage struct {
@@ -255,13 +459,63 @@ func (h *libHandler) searchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	books, moreResults, err := h.lib.SearchPaged(val[0], offset, limit, limit*(maxPageLinks-1))
+	found, moreResults, suggestions, err := h.lib.SearchPaged(val[0], offset, limit, limit*(maxPageLinks-1))
 	if err != nil {
+		if qe, ok := err.(books.QueryError); ok {
+			render("error_page", w, errorPage{"Invalid search", qe.Error()})
+			return
+		}
 		log.Printf("Error searching for %s: %s", val[0], err)
 		render("error_page", w, errorPage{"Error while searching", "An error occurred while searching."})
 		return
 	}
 
+	res := pagedResults(found, pageNumber, limit, moreResults, maxPageLinks)
+	res.Query = val[0]
+	res.Suggestions = suggestions
+	render("results", w, res)
+}
+
+// savedSearchHandler shows the books found by a saved search, which are searched for again on every view.
+func (h *libHandler) savedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	pageNumber, offset, limit := 1, 0, itemsPerPage
+	maxPageLinks := 10
+	name := mux.Vars(r)["name"]
+	s, ok, err := h.lib.GetSavedSearch(name)
+	if err != nil {
+		log.Printf("Error getting saved search %s: %s", name, err)
+		render("error_page", w, errorPage{"Error getting saved search", "An error occurred while getting that saved search."})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		render("error_page", w, errorPage{"Saved search not found", "There is no saved search with that name."})
+		return
+	}
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page >= 1 {
+		pageNumber = page
+		offset = (pageNumber - 1) * limit
+	}
+
+	found, moreResults, err := h.lib.SearchSaved(s, offset, limit, limit*(maxPageLinks-1))
+	if err != nil {
+		if qe, ok := err.(books.QueryError); ok {
+			render("error_page", w, errorPage{"Invalid saved search", qe.Error()})
+			return
+		}
+		log.Printf("Error running saved search %s: %s", s.Name, err)
+		render("error_page", w, errorPage{"Error while searching", "An error occurred while searching."})
+		return
+	}
+	res := pagedResults(found, pageNumber, limit, moreResults, maxPageLinks)
+	res.Query = s.Query
+	res.Saved = s.Name
+	render("results", w, res)
+}
+
+// pagedResults returns a page of results, with links to up to maxPageLinks pages around it.
+// moreResults is the number of results after this page.
+func pagedResults(found []books.Book, pageNumber, limit, moreResults, maxPageLinks int) results {
 	morePages := int(math.Ceil(float64(moreResults) / float64(limit)))
 	firstPageLink := pageNumber - int(math.Ceil(float64(maxPageLinks)/2)) + 1
 	if firstPageLink < 1 {
@@ -283,15 +537,13 @@ func (h *libHandler) searchHandler(w http.ResponseWriter, r *http.Request) {
 		nextPage = pageNumber + 1
 	}
 
-	res := results{
-		Books:      books,
+	return results{
+		Books:      found,
 		PageNumber: pageNumber,
 		Prev:       pageNumber - 1,
 		Next:       nextPage,
 		PageLinks:  pageLinks,
-		Query:      val[0],
 	}
-	render("results", w, res)
 }
 
 // render renders the template specified by name to w, and sets dot (.) to data.
@@ -325,9 +577,14 @@ func bookConverterWorker(h *libHandler) {
 	}
 }
 
+// searchQuery returns a query which searches field for value as a phrase.
+func searchQuery(field, value string) string {
+	return books.Term{Field: field, Value: value, Phrase: true}.String()
+}
+
 // searchFor wraps each item in a slice of strings with
 // a link to search for that item in the library.
-// Spaces will be replaced with +.
+// Each item is quoted, so that it's searched for as a phrase.
 // If field is not empty, the search will be limited to that field.
 func searchFor(field string, items []string) []string {
 	if field != "" {
@@ -336,14 +593,20 @@ func searchFor(field string, items []string) []string {
 
 	newItems := make([]string, len(items))
 	for i := range items {
-		newItems[i] = fmt.Sprintf(`<a href="/search/?query=%s%s">%s</a>`,
-			field, html.EscapeString(strings.Replace(items[i], " ", "%2B", -1)),
+		query := field + `"` + strings.Replace(items[i], `"`, "", -1) + `"`
+		newItems[i] = fmt.Sprintf(`<a href="/search/?query=%s">%s</a>`,
+			html.EscapeString(url.QueryEscape(query)),
 			html.EscapeString(items[i]))
 	}
 
 	return newItems
 }
 
+// highlight escapes a search snippet for HTML, and marks the text that matched the search.
+func highlight(snippet string) template.HTML {
+	return template.HTML(books.HighlightHTML(snippet))
+}
+
 // changeExt changes the extension of pathname to ext, which should include ..
 func changeExt(pathname string, ext string) string {
 	return strings.TrimSuffix(pathname, path.Ext(pathname)) + ext
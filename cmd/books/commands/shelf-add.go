@@ -0,0 +1,57 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+var shelfAddPosition int
+
+// shelfAddCmd represents the shelf add command
+var shelfAddCmd = &cobra.Command{
+	Use:   "add NAME BOOK_ID...",
+	Short: "Add books to a shelf",
+	Long: `Add books to the end of a shelf, in the order given, or at the position given with --position.
+Books which are already on the shelf are moved.
+
+Example:
+    books shelf add "Summer reading" 12 7 --position 1`,
+	Run: CPUProfile(shelfAddRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfAddCmd)
+
+	shelfAddCmd.Flags().IntVarP(&shelfAddPosition, "position", "p", 0, "Position to add the books at, counting from 1")
+}
+
+func shelfAddRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Specify a shelf and the IDs of the books to add to it.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	ids, err := parseIDs(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.AddToShelf(args[0], ids, shelfAddPosition); err != nil {
+		fmt.Fprintf(os.Stderr, "Error adding books to shelf: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfCreateCmd represents the shelf create command
+var shelfCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create an empty shelf",
+	Run:   CPUProfile(shelfCreateRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfCreateCmd)
+}
+
+func shelfCreateRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "No shelf name specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.CreateShelf(strings.Join(args, " ")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating shelf: %s\n", err)
+		os.Exit(1)
+	}
+}
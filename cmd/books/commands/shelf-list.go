@@ -0,0 +1,79 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfListCmd represents the shelf list command
+var shelfListCmd = &cobra.Command{
+	Use:   "list [NAME]",
+	Short: "List shelves, or the books on a shelf",
+	Long: `List every shelf, with the number of books on each.
+
+If a shelf name is given, the books on that shelf are listed in order.`,
+	Run: CPUProfile(shelfListRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfListCmd)
+}
+
+func shelfListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if len(args) == 0 {
+		out := mustOutputWriter("{{.Name}} ({{.Books}})\n", true)
+		shelves, err := lib.GetShelves()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting shelves: %s\n", err)
+			os.Exit(1)
+		}
+		for _, s := range shelves {
+			if err := out.Write(s); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing shelf: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := out.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing shelf: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	out := mustOutputWriter(`{{.Name}}
+{{range $i, $b := .Books}}{{inc $i}}: {{joinNaturally "and" $b.Authors}} - {{$b.Title}} ({{$b.ID}})
+{{end}}`, false)
+	name := strings.Join(args, " ")
+	shelf, found, err := lib.GetShelf(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting shelf: %s\n", err)
+		os.Exit(1)
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "Shelf %s not found.\n", name)
+		os.Exit(1)
+	}
+	if err := out.Write(shelf); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing shelf: %s\n", err)
+		os.Exit(1)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing shelf: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+var shelfRmDelete bool
+
+// shelfRmCmd represents the shelf rm command
+var shelfRmCmd = &cobra.Command{
+	Use:   "rm NAME [BOOK_ID...]",
+	Short: "Remove books from a shelf, or delete a shelf",
+	Long: `Remove the given books from a shelf, or delete the shelf itself with --delete.
+Either way, the books are kept in the library.`,
+	Run: CPUProfile(shelfRmRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfRmCmd)
+
+	shelfRmCmd.Flags().BoolVarP(&shelfRmDelete, "delete", "d", false, "Delete the shelf")
+}
+
+func shelfRmRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || (len(args) == 1 && !shelfRmDelete) || (len(args) > 1 && shelfRmDelete) {
+		fmt.Fprintln(os.Stderr, "Specify a shelf and the IDs of the books to remove from it, or a shelf and --delete.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	ids, err := parseIDs(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if shelfRmDelete {
+		if err := lib.DeleteShelf(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error deleting shelf: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := lib.RemoveFromShelf(args[0], ids); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing books from shelf: %s\n", err)
+		os.Exit(1)
+	}
+}
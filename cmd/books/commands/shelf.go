@@ -0,0 +1,24 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// shelfCmd represents the shelf command
+var shelfCmd = &cobra.Command{
+	Use:   "shelf",
+	Short: "Manage shelves",
+	Long: `Create shelves and arrange books on them.
+
+A shelf is a named collection of books, such as a reading list, which keeps the books in the order they're arranged in.
+A book can be on any number of shelves. Shelf names are matched ignoring case.
+Books on a shelf can be found with the search term shelf:NAME.`,
+}
+
+func init() {
+	rootCmd.AddCommand(shelfCmd)
+}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"os"
 	"strconv"
-	"text/template"
 
 	"github.com/tspivey/books"
 
@@ -37,6 +36,26 @@ func showRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	out := mustOutputWriter(`{{joinNaturally "and" .Authors}} - {{.Title }}
+{{if .Series}}Series: {{.Series}}{{if .SeriesIndex}} #{{.SeriesIndex}}{{end}}
+{{end }}{{range .Identifiers}}{{.Type}}: {{.Value}}
+{{end}}{{if .Publisher}}Publisher: {{.Publisher}}
+{{end}}{{if .Published}}Published: {{.Published}}
+{{end}}{{if .Language}}Language: {{.Language}}
+{{end}}{{if .Subjects}}Subjects: {{join .Subjects ", "}}
+{{end}}{{if .Shelves}}Shelves: {{join .Shelves ", "}}
+{{end}}{{range $name, $value := .Fields}}{{$name}}: {{$value}}
+{{end}}{{template "reading" .Reading}}{{if .Description}}
+{{.Description}}
+{{end}}
+{{ if .Files}}{{range .Files -}}
+{{ .Extension -}}
+: {{if .Tags}}({{range $i, $v := .Tags -}}
+{{if $i}}, {{end -}}
+{{ $v }}{{end}}){{end }} ({{ .ID }})
+{{ end -}}
+{{ else }}No files available for this book{{ end }}`+readingTemplate, false)
+
 	lib, err := books.OpenLibrary(libraryFile, booksRoot)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
@@ -57,26 +76,12 @@ func showRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	bookDetailsTmplSrc := `{{joinNaturally "and" .Authors}} - {{.Title }}
-{{if .Series}}Series: {{.Series}}
-{{end }}
-{{ if .Files}}{{range .Files -}}
-{{ .Extension -}}
-: {{if .Tags}}({{range $i, $v := .Tags -}}
-{{if $i}}, {{end -}}
-{{ $v }}{{end}}){{end }} ({{ .ID }})
-{{ end -}}
-{{ else }}No files available for this book{{ end }}`
-
-	tmpl, err := template.New("book_details").Funcs(funcMap).Parse(bookDetailsTmplSrc)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing template: %s\n", err)
+	if err := out.Write(books[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing book: %s\n", err)
 		os.Exit(1)
 	}
-
-	err = tmpl.Execute(os.Stdout, books[0])
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error executing template: %s\n", err)
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing book: %s\n", err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,113 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// splitCmd represents the split command
+var splitCmd = &cobra.Command{
+	Use:   "split FILE_ID",
+	Short: "Split a file out of its book",
+	Long: `Move a file out of its book and into another one. This is the inverse of merge.
+
+Use --book to move the file into an existing book.
+Otherwise, the file is moved into the book with the given title, authors, and series,
+which is created if it doesn't exist. Any of these not given are copied from the file's current book.
+If the file's current book has no files left, it is removed.
+
+Examples:
+    books split 12 --book 34
+    books split 12 --title "Stone of Tears"
+    books split 12 --authors "Terry Goodkind & Someone Else"`,
+	Run: CPUProfile(splitRun),
+}
+
+func init() {
+	rootCmd.AddCommand(splitCmd)
+
+	splitCmd.Flags().Int64P("book", "b", 0, "ID of an existing book to move the file into")
+	splitCmd.Flags().StringP("title", "t", "", "Title of the new book")
+	splitCmd.Flags().StringP("authors", "a", "", "Authors of the new book, separated by &")
+	splitCmd.Flags().StringP("series", "s", "", "Series of the new book")
+	splitCmd.Flags().String("series-index", "", "Position of the new book in its series, such as 2 or 2.5, or none")
+}
+
+func splitRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "No file ID specified.")
+		os.Exit(1)
+	}
+	fileID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "File ID must be a number.")
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	var newBook books.Book
+	newBook.ID, _ = cmd.Flags().GetInt64("book")
+	if newBook.ID == 0 {
+		files, err := lib.GetFilesByID([]int64{fileID})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while getting file by id: %s\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Fprintln(os.Stderr, "No file found")
+			os.Exit(1)
+		}
+		bookID, err := lib.GetBookIDByFilename(files[0].CurrentFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting book for file: %s\n", err)
+			os.Exit(1)
+		}
+		bks, err := lib.GetBooksByID([]int64{bookID})
+		if err != nil || len(bks) == 0 {
+			fmt.Fprintf(os.Stderr, "Error getting book for file: %v\n", err)
+			os.Exit(1)
+		}
+		newBook = bks[0]
+		newBook.ID = 0
+		if cmd.Flags().Changed("title") {
+			newBook.Title, _ = cmd.Flags().GetString("title")
+		}
+		if cmd.Flags().Changed("authors") {
+			authors, _ := cmd.Flags().GetString("authors")
+			newBook.Authors = strings.Split(authors, " & ")
+		}
+		if cmd.Flags().Changed("series") {
+			newBook.Series, _ = cmd.Flags().GetString("series")
+		}
+		if cmd.Flags().Changed("series-index") {
+			s, _ := cmd.Flags().GetString("series-index")
+			newBook.SeriesIndex, err = books.ParseSeriesIndex(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	id, err := lib.SplitFile(fileID, newBook)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error splitting file: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Moved file %d to book %d\n", fileID, id)
+}
@@ -0,0 +1,51 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// tagsListCmd represents the tags list command
+var tagsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tags",
+	Long:  `List every tag in the library, with the number of books which have a file with that tag.`,
+	Run:   CPUProfile(tagsListRun),
+}
+
+func init() {
+	tagsCmd.AddCommand(tagsListCmd)
+}
+
+func tagsListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	out := mustOutputWriter("{{.Name}} ({{.Books}})\n", true)
+	tags, err := lib.GetTags()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting tags: %s\n", err)
+		os.Exit(1)
+	}
+	for _, tag := range tags {
+		if err := out.Write(tag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing tag: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing tag: %s\n", err)
+		os.Exit(1)
+	}
+}
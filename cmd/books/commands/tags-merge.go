@@ -0,0 +1,55 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// tagsMergeCmd represents the tags merge command
+var tagsMergeCmd = &cobra.Command{
+	Use:   "merge TAG... INTO",
+	Short: "Merge tags into one",
+	Long: `Replace each TAG with the last tag given on every file which has it, and remove it.
+The last tag is added if it doesn't exist yet.
+
+Example:
+    books tags merge OCR scanned ocr`,
+	Run: CPUProfile(tagsMergeRun),
+}
+
+func init() {
+	tagsCmd.AddCommand(tagsMergeCmd)
+}
+
+func tagsMergeRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Specify the tags to merge and the tag to merge them into.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	ids, err := lib.MergeTags(args[:len(args)-1], args[len(args)-1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging tags: %s\n", err)
+		os.Exit(1)
+	}
+	renameBookFiles(lib, tmpl, ids)
+}
@@ -0,0 +1,53 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// tagsRenameCmd represents the tags rename command
+var tagsRenameCmd = &cobra.Command{
+	Use:   "rename OLD NEW",
+	Short: "Rename a tag",
+	Long: `Rename a tag on every file which has it.
+
+If a tag named NEW already exists, use books tags merge instead.`,
+	Run: CPUProfile(tagsRenameRun),
+}
+
+func init() {
+	tagsCmd.AddCommand(tagsRenameCmd)
+}
+
+func tagsRenameRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Specify the tag to rename and its new name.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	ids, err := lib.RenameTag(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming tag: %s\n", err)
+		os.Exit(1)
+	}
+	renameBookFiles(lib, tmpl, ids)
+}
@@ -0,0 +1,53 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// tagsRmCmd represents the tags rm command
+var tagsRmCmd = &cobra.Command{
+	Use:   "rm TAG...",
+	Short: "Remove tags",
+	Long:  `Remove each TAG from every file which has it. The files themselves are kept.`,
+	Run:   CPUProfile(tagsRmRun),
+}
+
+func init() {
+	tagsCmd.AddCommand(tagsRmCmd)
+}
+
+func tagsRmRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "No tags specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	for _, tag := range args {
+		ids, err := lib.RemoveTag(tag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing tag: %s\n", err)
+			os.Exit(1)
+		}
+		renameBookFiles(lib, tmpl, ids)
+	}
+}
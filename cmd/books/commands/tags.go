@@ -0,0 +1,24 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// tagsCmd represents the tags command
+var tagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "Manage tags",
+	Long: `List, rename, merge and remove the tags of book files.
+
+Tags are read from the parts of a file name in parentheses when it's imported, as in "Title (retail).epub",
+and can be changed for a single file with the addtag and rmtag commands of books edit.
+Files whose tags change are renamed to match the output template.`,
+}
+
+func init() {
+	rootCmd.AddCommand(tagsCmd)
+}
@@ -41,6 +41,11 @@ func updateFunc(cmd *cobra.Command, args []string) {
 		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
 		os.Exit(1)
 	}
+	tmpl, err := loadOutputTemplate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 	library, err := books.OpenLibrary(libraryFile, booksRoot)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
@@ -75,5 +80,13 @@ func updateFunc(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "Error updating book: %s\n", err)
 		os.Exit(1)
 	}
+	renamed, err := library.RenameFiles([]int64{book.ID}, tmpl, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming files: %s\n", err)
+		os.Exit(1)
+	}
+	for _, r := range renamed {
+		log.Printf("Renamed %s to %s\n", r.OldName, r.NewName)
+	}
 
 }
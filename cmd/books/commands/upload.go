@@ -0,0 +1,284 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// uploadsDir holds uploaded files until they're imported.
+// Files which couldn't be parsed stay here until their metadata is entered, or the server restarts.
+var uploadsDir string
+
+// uploadForm is the data for the upload template.
+type uploadForm struct {
+	CSRFToken string
+}
+
+// uploadResult is the outcome of importing a single uploaded file.
+// Status is imported, duplicate, unparsed, or error.
+// Unparsed files are kept in the directory named by UploadID until their metadata is entered by hand.
+type uploadResult struct {
+	Filename string
+	Status   string
+	Book     *books.Book
+	Error    string
+	UploadID string
+}
+
+// uploadResults is the data for the upload_results template.
+type uploadResults struct {
+	Results   []uploadResult
+	CSRFToken string
+}
+
+func init() {
+	viper.SetDefault("server.max_upload_size", 100)
+	viper.SetDefault("server.upload_timeout", 600)
+}
+
+// initUploads empties the uploads directory, since files left from before a restart can't be imported without a new form.
+func initUploads() error {
+	uploadsDir = path.Join(cacheDir, "uploads")
+	if err := os.RemoveAll(uploadsDir); err != nil {
+		return err
+	}
+	return os.MkdirAll(uploadsDir, 0755)
+}
+
+// uploadFormHandler shows the form for uploading books.
+func (h *libHandler) uploadFormHandler(w http.ResponseWriter, r *http.Request) {
+	user := h.uploadingUser(r)
+	if user == "" {
+		w.WriteHeader(http.StatusForbidden)
+		render("error_page", w, errorPage{"Uploading not allowed", "Uploading is only available when logged in."})
+		return
+	}
+	render("upload", w, uploadForm{csrfToken(user, time.Now())})
+}
+
+// uploadHandler imports the files posted from the upload form, using the configured metadata parsers and output template.
+func (h *libHandler) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if h.uploadingUser(r) == "" {
+		w.WriteHeader(http.StatusForbidden)
+		render("error_page", w, errorPage{"Uploading not allowed", "Uploading is only available when logged in."})
+		return
+	}
+	// Uploads can take much longer than the read timeout of other requests, so they have their own.
+	// Only the read deadline is extended; a non-zero server.write_timeout still ends the request when it runs out.
+	var deadline time.Time
+	if timeout := viper.GetDuration("server.upload_timeout") * time.Second; timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := http.NewResponseController(w).SetReadDeadline(deadline); err != nil {
+		log.Printf("Error setting the upload read deadline: %s", err)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, viper.GetInt64("server.max_upload_size")<<20)
+	user, ok := h.checkEditRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if r.MultipartForm == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"No files uploaded", "Choose at least one file to upload."})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	res := uploadResults{CSRFToken: csrfToken(user, time.Now())}
+	for _, fh := range r.MultipartForm.File["files"] {
+		result := h.importUpload(fh)
+		if result.Status != "error" {
+			log.Printf("User %s uploaded %s: %s", user, result.Filename, result.Status)
+		}
+		res.Results = append(res.Results, result)
+	}
+	if len(res.Results) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"No files uploaded", "Choose at least one file to upload."})
+		return
+	}
+	render("upload_results", w, res)
+}
+
+// uploadMetadataHandler imports an uploaded file which couldn't be parsed, using the metadata entered for it.
+// If the discard field is set, the file is deleted instead.
+func (h *libHandler) uploadMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if h.uploadingUser(r) == "" {
+		w.WriteHeader(http.StatusForbidden)
+		render("error_page", w, errorPage{"Uploading not allowed", "Uploading is only available when logged in."})
+		return
+	}
+	user, ok := h.checkEditRequest(w, r)
+	if !ok {
+		return
+	}
+
+	id, filename := r.PostFormValue("upload_id"), r.PostFormValue("filename")
+	if !isPlainName(id) || !isPlainName(filename) {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid upload", "That uploaded file doesn't exist."})
+		return
+	}
+	dir := path.Join(uploadsDir, id)
+	fn := path.Join(dir, filename)
+	if _, err := os.Stat(fn); err != nil {
+		render("error_page", w, errorPage{"Upload not found", "That uploaded file no longer exists. Try uploading it again."})
+		return
+	}
+	if r.PostFormValue("discard") != "" {
+		os.RemoveAll(dir)
+		http.Redirect(w, r, "/upload", http.StatusSeeOther)
+		return
+	}
+
+	book := books.Book{Title: strings.TrimSpace(r.PostFormValue("title")), Series: strings.TrimSpace(r.PostFormValue("series"))}
+	for _, author := range strings.Split(r.PostFormValue("authors"), " & ") {
+		if author = strings.TrimSpace(author); author != "" {
+			book.Authors = append(book.Authors, author)
+		}
+	}
+	if book.Title == "" || len(book.Authors) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid book", "A book must have a title and at least one author."})
+		return
+	}
+	var err error
+	if book.SeriesIndex, err = books.ParseSeriesIndex(r.PostFormValue("series_index")); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid book", err.Error()})
+		return
+	}
+
+	result := h.importUploadedFile(dir, filename, book, true)
+	log.Printf("User %s entered metadata for %s: %s", user, filename, result.Status)
+	render("upload_results", w, uploadResults{[]uploadResult{result}, csrfToken(user, time.Now())})
+}
+
+// uploadingUser returns the name of the user making the request if they may upload books.
+// Uploading needs both a logged in user and working metadata parsers.
+func (h *libHandler) uploadingUser(r *http.Request) string {
+	if !h.canUpload {
+		return ""
+	}
+	return h.editingUser(r)
+}
+
+// importUpload saves an uploaded file to its own directory in uploadsDir, and imports it if its metadata can be parsed.
+func (h *libHandler) importUpload(fh *multipart.FileHeader) uploadResult {
+	filename := filepath.Base(strings.Replace(fh.Filename, "\\", "/", -1))
+	result := uploadResult{Filename: filename, Status: "error"}
+	if !isPlainName(filename) {
+		result.Error = "Invalid filename"
+		return result
+	}
+
+	dir, err := ioutil.TempDir(uploadsDir, "")
+	if err != nil {
+		log.Printf("Error creating upload directory: %s", err)
+		result.Error = "The file couldn't be saved"
+		return result
+	}
+	if err := saveUpload(fh, path.Join(dir, filename)); err != nil {
+		log.Printf("Error saving upload %s: %s", filename, err)
+		os.RemoveAll(dir)
+		result.Error = "The file couldn't be saved"
+		return result
+	}
+
+	book, matched := parseMetadata(path.Join(dir, filename))
+	if !matched {
+		result.Status = "unparsed"
+		result.UploadID = path.Base(dir)
+		return result
+	}
+	return h.importUploadedFile(dir, filename, book, false)
+}
+
+// importUploadedFile moves an uploaded file into the library as a file of book, and removes its upload directory.
+// If keepUnparsed is true, the upload is kept when importing fails for a reason other than a duplicate,
+// so the metadata can be entered again.
+func (h *libHandler) importUploadedFile(dir, filename string, book books.Book, keepUnparsed bool) uploadResult {
+	result := uploadResult{Filename: filename, Status: "imported"}
+	h.importMtx.Lock()
+	id, err := importParsedBook(path.Join(dir, filename), book, h.lib, true)
+	if err == nil {
+		if err := h.setUploadedFilename(id, path.Join(dir, filename), filename); err != nil {
+			log.Printf("Cannot set the original filename of uploaded book %s: %s", filename, err)
+		}
+	}
+	h.importMtx.Unlock()
+	if err != nil {
+		log.Printf("Cannot import uploaded book %s: %s", filename, err)
+		result.Status, result.Error = "error", err.Error()
+		if _, ok := errors.Cause(err).(books.DuplicateFileError); ok {
+			result.Status = "duplicate"
+		} else if keepUnparsed {
+			result.UploadID = path.Base(dir)
+			return result
+		}
+	} else if bks, err := h.lib.GetBooksByID([]int64{id}); err == nil && len(bks) == 1 {
+		result.Book = &bks[0]
+	}
+	os.RemoveAll(dir)
+	return result
+}
+
+// setUploadedFilename records the name a file was uploaded with as its original filename,
+// instead of the path in the uploads directory it was imported from, which no longer exists.
+func (h *libHandler) setUploadedFilename(bookID int64, fn, filename string) error {
+	bks, err := h.lib.GetBooksByID([]int64{bookID})
+	if err != nil {
+		return errors.Wrap(err, "get imported book")
+	}
+	for _, b := range bks {
+		for _, bf := range b.Files {
+			if bf.OriginalFilename == fn {
+				return h.lib.SetOriginalFilename(bf.ID, filename)
+			}
+		}
+	}
+	return nil
+}
+
+// saveUpload copies an uploaded file to fn.
+func saveUpload(fh *multipart.FileHeader, fn string) (e error) {
+	src, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dst.Close(); err != nil && e == nil {
+			e = err
+		}
+	}()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// isPlainName returns true if name can be used as a file name without leaving its directory.
+func isPlainName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
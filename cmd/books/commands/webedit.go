@@ -0,0 +1,318 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// csrfTokenLifetime is how long a form can be submitted after it was shown.
+const csrfTokenLifetime = 12 * time.Hour
+
+// multipartMemory is how much of a multipart form is kept in memory; the rest is stored in temporary files.
+const multipartMemory = 32 << 20
+
+// csrfKey signs CSRF tokens. It's generated when the server starts, so forms shown before a restart must be reloaded.
+var csrfKey []byte
+
+// bookDetails is the data for the book_details template.
+type bookDetails struct {
+	books.Book
+	CanEdit   bool
+	CSRFToken string
+	// Statuses and Ratings are the choices for the reading status and rating in the edit form.
+	Statuses []books.ReadingStatus
+	Ratings  []int
+}
+
+// bookMerge is the data for the book_merge template, which offers to merge an edited book into an existing one.
+type bookMerge struct {
+	Book        books.Book
+	Existing    books.Book
+	Series      string
+	SeriesIndex float64
+	CSRFToken   string
+}
+
+// initCSRFKey generates a new key for signing CSRF tokens.
+func initCSRFKey() error {
+	csrfKey = make([]byte, 32)
+	_, err := rand.Read(csrfKey)
+	return err
+}
+
+// csrfToken returns a token which lets user submit a form until csrfTokenLifetime after t.
+func csrfToken(user string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return ts + ":" + csrfMAC(user, ts)
+}
+
+// checkCSRFToken returns true if token was made for user by csrfToken, and hasn't expired.
+func checkCSRFToken(user, token string) bool {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > csrfTokenLifetime {
+		return false
+	}
+	return hmac.Equal([]byte(parts[1]), []byte(csrfMAC(user, parts[0])))
+}
+
+func csrfMAC(user, ts string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(user + "\x00" + ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// editingUser returns the name of the user making the request if they may edit the library.
+// Editing is only allowed when the server requires logging in with an htpasswd file.
+func (h *libHandler) editingUser(r *http.Request) string {
+	if h.authenticator == nil {
+		return ""
+	}
+	return h.authenticator.CheckAuth(r)
+}
+
+// checkEditRequest makes sure the request comes from a user who may edit, using a form they were shown.
+// If not, an error page is rendered and false is returned.
+func (h *libHandler) checkEditRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user := h.editingUser(r)
+	if user == "" {
+		w.WriteHeader(http.StatusForbidden)
+		render("error_page", w, errorPage{"Editing not allowed", "Editing is only available when logged in."})
+		return "", false
+	}
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(multipartMemory)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid form", "The form couldn't be read."})
+		return "", false
+	}
+	if !checkCSRFToken(user, r.PostFormValue("csrf_token")) {
+		w.WriteHeader(http.StatusForbidden)
+		render("error_page", w, errorPage{"Form expired", "The form has expired. Go back, reload the page, and try again."})
+		return "", false
+	}
+	return user, true
+}
+
+// getBook returns the book whose ID is in the request's path.
+// If it can't be found, an error page is rendered and false is returned.
+func (h *libHandler) getBook(w http.ResponseWriter, r *http.Request) (books.Book, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return books.Book{}, false
+	}
+	found, err := h.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting books by ID: %s", err)
+		http.NotFound(w, r)
+		return books.Book{}, false
+	}
+	if len(found) == 0 {
+		render("error_page", w, errorPage{"Book not found", "That book doesn't exist in the library."})
+		return books.Book{}, false
+	}
+	return found[0], true
+}
+
+// bookEditHandler saves the title, authors, series, identifiers, reading information and file tags posted from the form on the book details page.
+// If another book already has the new title and authors, it offers to merge this book into it.
+func (h *libHandler) bookEditHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.checkEditRequest(w, r)
+	if !ok {
+		return
+	}
+	book, ok := h.getBook(w, r)
+	if !ok {
+		return
+	}
+
+	newBook := books.Book{ID: book.ID, Title: strings.TrimSpace(r.PostFormValue("title")), Series: strings.TrimSpace(r.PostFormValue("series"))}
+	for _, author := range strings.Split(r.PostFormValue("authors"), " & ") {
+		if author = strings.TrimSpace(author); author != "" {
+			newBook.Authors = append(newBook.Authors, author)
+		}
+	}
+	if newBook.Title == "" || len(newBook.Authors) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid book", "A book must have a title and at least one author."})
+		return
+	}
+	seriesIndex, err := books.ParseSeriesIndex(r.PostFormValue("series_index"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid book", err.Error()})
+		return
+	}
+	newBook.SeriesIndex = seriesIndex
+
+	reading, err := readingFromForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid reading information", err.Error()})
+		return
+	}
+
+	ids, err := books.ParseIdentifiers(r.PostFormValue("identifiers"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid identifiers", err.Error()})
+		return
+	}
+
+	// Tags, identifiers and reading information are saved first, so they're kept even if the book ends up being merged.
+	if err := h.lib.SetIdentifiers(book.ID, ids); err != nil {
+		if iee, ok := err.(books.IdentifierExistsError); ok {
+			w.WriteHeader(http.StatusConflict)
+			render("error_page", w, errorPage{"Identifier already in use", fmt.Sprintf("Book %d already has the identifier %s.", iee.BookID, iee.Identifier)})
+			return
+		}
+		log.Printf("Error setting identifiers for book %d: %s", book.ID, err)
+		render("error_page", w, errorPage{"Error saving book", "The identifiers couldn't be saved."})
+		return
+	}
+	if err := h.lib.SetReading(book.ID, reading); err != nil {
+		log.Printf("Error setting reading information for book %d: %s", book.ID, err)
+		render("error_page", w, errorPage{"Error saving book", "The reading information couldn't be saved."})
+		return
+	}
+	for _, bf := range book.Files {
+		tags := splitTagList(r.PostFormValue("tags-" + strconv.FormatInt(bf.ID, 10)))
+		if strings.Join(tags, "\x00") == strings.Join(bf.Tags, "\x00") {
+			continue
+		}
+		if err := h.lib.SetFileTags(bf.ID, tags); err != nil {
+			log.Printf("Error setting tags for file %d: %s", bf.ID, err)
+			render("error_page", w, errorPage{"Error saving book", "The file tags couldn't be saved."})
+			return
+		}
+	}
+
+	err = h.lib.UpdateBook(newBook, true)
+	if bee, ok := err.(books.BookExistsError); ok {
+		existing, err := h.lib.GetBooksByID([]int64{bee.BookID})
+		if err != nil || len(existing) == 0 {
+			log.Printf("Error getting existing book %d: %s", bee.BookID, err)
+			render("error_page", w, errorPage{"Error saving book", "Another book already has that title and authors."})
+			return
+		}
+		render("book_merge", w, bookMerge{book, existing[0], newBook.Series, newBook.SeriesIndex, csrfToken(user, time.Now())})
+		return
+	} else if err != nil {
+		log.Printf("Error updating book %d: %s", book.ID, err)
+		render("error_page", w, errorPage{"Error saving book", "The book couldn't be saved."})
+		return
+	}
+	log.Printf("User %s edited book %d", user, book.ID)
+
+	h.renameFiles(book.ID)
+	http.Redirect(w, r, "/book/"+strconv.FormatInt(book.ID, 10), http.StatusSeeOther)
+}
+
+// bookMergeHandler merges a book into the one posted in the into field, after an edit gave them the same title and authors.
+// The edited series and series index are then applied to the merged book.
+func (h *libHandler) bookMergeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.checkEditRequest(w, r)
+	if !ok {
+		return
+	}
+	book, ok := h.getBook(w, r)
+	if !ok {
+		return
+	}
+	into, err := strconv.ParseInt(r.PostFormValue("into"), 10, 64)
+	if err != nil || into == book.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid book", "The book to merge into is invalid."})
+		return
+	}
+	existing, err := h.lib.GetBooksByID([]int64{into})
+	if err != nil || len(existing) == 0 {
+		render("error_page", w, errorPage{"Book not found", "The book to merge into doesn't exist in the library."})
+		return
+	}
+	seriesIndex, err := books.ParseSeriesIndex(r.PostFormValue("series_index"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render("error_page", w, errorPage{"Invalid book", err.Error()})
+		return
+	}
+
+	if err := h.lib.MergeBooks([]int64{into, book.ID}); err != nil {
+		log.Printf("Error merging book %d into %d: %s", book.ID, into, err)
+		render("error_page", w, errorPage{"Error merging books", "The books couldn't be merged."})
+		return
+	}
+	log.Printf("User %s merged book %d into %d", user, book.ID, into)
+
+	merged := existing[0]
+	merged.Series = strings.TrimSpace(r.PostFormValue("series"))
+	merged.SeriesIndex = seriesIndex
+	if err := h.lib.UpdateBook(merged, true); err != nil {
+		log.Printf("Error updating series of book %d: %s", into, err)
+	}
+	h.renameFiles(into)
+	http.Redirect(w, r, "/book/"+strconv.FormatInt(into, 10), http.StatusSeeOther)
+}
+
+// renameFiles renames the files of a book to match the output template after it was edited.
+// Errors are only logged, since the edit itself has already been saved.
+func (h *libHandler) renameFiles(id int64) {
+	if _, err := h.lib.RenameFiles([]int64{id}, h.outputTmpl, false); err != nil {
+		log.Printf("Error renaming files of book %d: %s", id, err)
+	}
+}
+
+// readingFromForm reads the reading status, dates, rating and notes from the book edit form.
+func readingFromForm(r *http.Request) (books.Reading, error) {
+	var reading books.Reading
+	var err error
+	if reading.Status, err = books.ParseReadingStatus(r.PostFormValue("status")); err != nil {
+		return reading, err
+	}
+	if reading.Started, err = books.ParseReadingDate(r.PostFormValue("started")); err != nil {
+		return reading, err
+	}
+	if reading.Finished, err = books.ParseReadingDate(r.PostFormValue("finished")); err != nil {
+		return reading, err
+	}
+	if reading.Rating, err = books.ParseRating(r.PostFormValue("rating")); err != nil {
+		return reading, err
+	}
+	reading.Notes = strings.TrimSpace(strings.Replace(r.PostFormValue("notes"), "\r\n", "\n", -1))
+	return reading, nil
+}
+
+// splitTagList splits a comma separated list of tags, ignoring empty ones.
+func splitTagList(s string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
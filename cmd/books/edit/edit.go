@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/tspivey/books"
 )
@@ -26,9 +30,10 @@ type DefaultCommand struct {
 
 // Parser contains the set of available commands, and the shared state for those commands.
 type Parser struct {
-	book     *books.Book
-	lib      *books.Library
-	commands map[string]*DefaultCommand
+	book       *books.Book
+	lib        *books.Library
+	outputTmpl *template.Template
+	commands   map[string]*DefaultCommand
 }
 
 // RunCommand runs a command with the given arguments, returning ErrUnknownCommand if not found.
@@ -44,6 +49,67 @@ func (p *Parser) RunCommand(cmd string, args string) error {
 	return nil
 }
 
+// reload replaces the edited book with the book with the given ID from the library, discarding unsaved changes.
+func (p *Parser) reload(id int64) error {
+	bks, err := p.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		return err
+	}
+	if len(bks) == 0 {
+		return errors.New("book not found")
+	}
+	*p.book = bks[0]
+	return nil
+}
+
+// hasUnsavedChanges returns true if the edited book differs from the book stored in the library.
+func (p *Parser) hasUnsavedChanges() (bool, error) {
+	bks, err := p.lib.GetBooksByID([]int64{p.book.ID})
+	if err != nil {
+		return false, err
+	}
+	if len(bks) == 0 {
+		return false, errors.New("book not found")
+	}
+	return !reflect.DeepEqual(bks[0], *p.book), nil
+}
+
+// renameFiles renames the files of the book with the given ID to match the output template, and reports what was renamed.
+func (p *Parser) renameFiles(id int64) {
+	renamed, err := p.lib.RenameFiles([]int64{id}, p.outputTmpl, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming files: %v\n", err)
+		return
+	}
+	for _, r := range renamed {
+		fmt.Printf("Renamed %s to %s\n", r.OldName, r.NewName)
+	}
+}
+
+// saveTags saves the tags of each file of the edited book whose tags were changed.
+func (p *Parser) saveTags() error {
+	bks, err := p.lib.GetBooksByID([]int64{p.book.ID})
+	if err != nil {
+		return err
+	}
+	if len(bks) == 0 {
+		return errors.New("book not found")
+	}
+	saved := make(map[int64][]string)
+	for _, bf := range bks[0].Files {
+		saved[bf.ID] = bf.Tags
+	}
+	for _, bf := range p.book.Files {
+		if strings.Join(bf.Tags, "\x00") == strings.Join(saved[bf.ID], "\x00") {
+			continue
+		}
+		if err := p.lib.SetFileTags(bf.ID, bf.Tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Completer tries to complete a command and its arguments.
 func (p *Parser) Completer(s string) []string {
 	s = strings.TrimSpace(s)
@@ -119,9 +185,354 @@ var seriesCmd = &DefaultCommand{
 	},
 }
 
+var seriesIndexCmd = &DefaultCommand{
+	Help: "Sets the position of the currently edited book in its series, such as 2 or 2.5, or none",
+	Run: func(cmd *DefaultCommand, args string) {
+		if args == "" {
+			fmt.Fprintf(os.Stderr, "Usage: seriesindex <number|none>\n")
+			return
+		}
+		index, err := books.ParseSeriesIndex(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		cmd.parser.book.SeriesIndex = index
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("seriesindex", s) {
+			return []string{}
+		}
+		if cmd.parser.book.SeriesIndex == 0 {
+			return []string{"seriesindex "}
+		}
+		return []string{"seriesindex " + strconv.FormatFloat(cmd.parser.book.SeriesIndex, 'f', -1, 64)}
+	},
+}
+
+var statusCmd = &DefaultCommand{
+	Help: "Sets the reading status of the currently edited book: want-to-read, reading, finished, abandoned, or none",
+	Run: func(cmd *DefaultCommand, args string) {
+		if args == "" {
+			fmt.Fprintf(os.Stderr, "Usage: status <want-to-read|reading|finished|abandoned|none>\n")
+			return
+		}
+		status, err := books.ParseReadingStatus(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		cmd.parser.book.Reading.Status = status
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix(s, "status") && !strings.HasPrefix("status", s) {
+			return []string{}
+		}
+		completions := []string{}
+		for _, status := range append(append([]books.ReadingStatus{}, books.ReadingStatuses...), "none") {
+			if c := "status " + string(status); strings.HasPrefix(c, s) {
+				completions = append(completions, c)
+			}
+		}
+		return completions
+	},
+}
+
+var startedCmd = &DefaultCommand{
+	Help: "Sets the date the currently edited book was started, as YYYY-MM-DD, or none",
+	Run: func(cmd *DefaultCommand, args string) {
+		setReadingDate(&cmd.parser.book.Reading.Started, "started", args)
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		return completeReadingDate("started", cmd.parser.book.Reading.Started, s)
+	},
+}
+
+var finishedCmd = &DefaultCommand{
+	Help: "Sets the date the currently edited book was finished, as YYYY-MM-DD, or none",
+	Run: func(cmd *DefaultCommand, args string) {
+		setReadingDate(&cmd.parser.book.Reading.Finished, "finished", args)
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		return completeReadingDate("finished", cmd.parser.book.Reading.Finished, s)
+	},
+}
+
+// setReadingDate parses args as the date for the command named name, and stores it in date.
+func setReadingDate(date **time.Time, name, args string) {
+	if args == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s <YYYY-MM-DD|today|none>\n", name)
+		return
+	}
+	if args == "today" {
+		args = time.Now().Format("2006-01-02")
+	}
+	t, err := books.ParseReadingDate(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
+	*date = t
+}
+
+// completeReadingDate completes the command named name with the current date, or today if it isn't set.
+func completeReadingDate(name string, date *time.Time, s string) []string {
+	if !strings.HasPrefix(name, s) {
+		return []string{}
+	}
+	if date == nil {
+		return []string{name + " " + time.Now().Format("2006-01-02")}
+	}
+	return []string{name + " " + date.Format("2006-01-02")}
+}
+
+var ratingCmd = &DefaultCommand{
+	Help: "Sets the rating of the currently edited book, from 1 to 5, or none",
+	Run: func(cmd *DefaultCommand, args string) {
+		if args == "" {
+			fmt.Fprintf(os.Stderr, "Usage: rating <1-%d|none>\n", books.MaxRating)
+			return
+		}
+		rating, err := books.ParseRating(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		cmd.parser.book.Reading.Rating = rating
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("rating", s) {
+			return []string{}
+		}
+		return []string{"rating "}
+	},
+}
+
+var notesCmd = &DefaultCommand{
+	Help: "Sets the notes for the currently edited book; with no notes, they're cleared",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.Reading.Notes = strings.TrimSpace(args)
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("notes", s) {
+			return []string{}
+		}
+		return []string{"notes " + cmd.parser.book.Reading.Notes}
+	},
+}
+
+var identifiersCmd = &DefaultCommand{
+	Help: "Sets the identifiers for the currently edited book, as a comma separated list such as isbn:9780765326355, asin:B003P2WO5E",
+	Run: func(cmd *DefaultCommand, args string) {
+		ids, err := books.ParseIdentifiers(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		cmd.parser.book.Identifiers = ids
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("identifiers", s) {
+			return []string{}
+		}
+		return []string{"identifiers " + joinIdentifiers(cmd.parser.book.Identifiers)}
+	},
+}
+
+// joinIdentifiers returns identifiers in the form accepted by the identifiers command.
+func joinIdentifiers(ids []books.Identifier) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+var fieldCmd = &DefaultCommand{
+	Help: "Sets a custom field of the currently edited book; with no value, it's cleared",
+	Run: func(cmd *DefaultCommand, args string) {
+		fields := strings.SplitN(strings.TrimSpace(args), " ", 2)
+		if fields[0] == "" {
+			fmt.Fprintf(os.Stderr, "Usage: field <name> [value]\n")
+			return
+		}
+		f, ok := findField(cmd.parser.lib.CustomFields(), fields[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown field %s. Custom fields are declared in the config file; see books fields.\n", fields[0])
+			return
+		}
+		book := cmd.parser.book
+		if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			delete(book.Fields, f.Name)
+			return
+		}
+		value, err := f.Normalize(fields[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		if book.Fields == nil {
+			book.Fields = make(map[string]string)
+		}
+		book.Fields[f.Name] = value
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix(s, "field") && !strings.HasPrefix("field", s) {
+			return []string{}
+		}
+		completions := []string{}
+		for _, f := range cmd.parser.lib.CustomFields() {
+			values := f.Values
+			if value, ok := cmd.parser.book.Fields[f.Name]; ok {
+				values = []string{value}
+			} else if f.Type == books.FieldBool {
+				values = []string{"true", "false"}
+			} else if len(values) == 0 {
+				values = []string{""}
+			}
+			for _, v := range values {
+				if c := "field " + f.Name + " " + v; strings.HasPrefix(c, s) {
+					completions = append(completions, c)
+				}
+			}
+		}
+		return completions
+	},
+}
+
+// findField returns the custom field with the given name, ignoring case.
+func findField(fields []books.CustomField, name string) (books.CustomField, bool) {
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return books.CustomField{}, false
+}
+
+var addTagCmd = &DefaultCommand{
+	Help: "Adds tags, separated by commas, to a file of the currently edited book",
+	Run: func(cmd *DefaultCommand, args string) {
+		bf, tags, ok := parseFileTags(cmd.parser.book, "addtag", args)
+		if !ok {
+			return
+		}
+		for _, tag := range tags {
+			if indexTag(bf.Tags, tag) < 0 {
+				bf.Tags = append(bf.Tags, tag)
+			}
+		}
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("addtag", s) {
+			return []string{}
+		}
+		completions := []string{}
+		for _, bf := range cmd.parser.book.Files {
+			completions = append(completions, "addtag "+strconv.FormatInt(bf.ID, 10)+" ")
+		}
+		return completions
+	},
+}
+
+var rmTagCmd = &DefaultCommand{
+	Help: "Removes tags, separated by commas, from a file of the currently edited book",
+	Run: func(cmd *DefaultCommand, args string) {
+		bf, tags, ok := parseFileTags(cmd.parser.book, "rmtag", args)
+		if !ok {
+			return
+		}
+		for _, tag := range tags {
+			i := indexTag(bf.Tags, tag)
+			if i < 0 {
+				fmt.Fprintf(os.Stderr, "File %d doesn't have the tag %s.\n", bf.ID, tag)
+				continue
+			}
+			bf.Tags = append(bf.Tags[:i:i], bf.Tags[i+1:]...)
+		}
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix(s, "rmtag") && !strings.HasPrefix("rmtag", s) {
+			return []string{}
+		}
+		completions := []string{}
+		for _, bf := range cmd.parser.book.Files {
+			for _, tag := range bf.Tags {
+				if c := "rmtag " + strconv.FormatInt(bf.ID, 10) + " " + tag; strings.HasPrefix(c, s) {
+					completions = append(completions, c)
+				}
+			}
+		}
+		return completions
+	},
+}
+
+// parseFileTags parses the arguments of the tag command named name, which are a file ID and a comma separated list of tags.
+// It returns the file of the book with that ID, and the tags.
+func parseFileTags(book *books.Book, name, args string) (*books.BookFile, []string, bool) {
+	fields := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if len(fields) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <file id> <tag>[, tag...]\n", name)
+		return nil, nil, false
+	}
+	fileID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid file ID.\n")
+		return nil, nil, false
+	}
+	var bf *books.BookFile
+	for i := range book.Files {
+		if book.Files[i].ID == fileID {
+			bf = &book.Files[i]
+		}
+	}
+	if bf == nil {
+		fmt.Fprintf(os.Stderr, "File %d doesn't belong to this book.\n", fileID)
+		return nil, nil, false
+	}
+	tags := []string{}
+	for _, tag := range strings.Split(fields[1], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <file id> <tag>[, tag...]\n", name)
+		return nil, nil, false
+	}
+	return bf, tags, true
+}
+
+// indexTag returns the index of tag in tags, ignoring case as searches do, or -1 if it isn't there.
+func indexTag(tags []string, tag string) int {
+	for i, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return i
+		}
+	}
+	return -1
+}
+
 var saveCmd = &DefaultCommand{
 	Help: "Saves the currently edited book",
 	Run: func(cmd *DefaultCommand, args string) {
+		// Tags, identifiers, custom fields and reading information are saved first, so that they're kept if the book is merged.
+		if err := cmd.parser.saveTags(); err != nil {
+			fmt.Fprintf(os.Stderr, "error while saving tags: %v\n", err)
+			return
+		}
+		if err := cmd.parser.lib.SetFields(cmd.parser.book.ID, cmd.parser.book.Fields); err != nil {
+			fmt.Fprintf(os.Stderr, "error while saving custom fields: %v\n", err)
+			return
+		}
+		if err := cmd.parser.lib.SetIdentifiers(cmd.parser.book.ID, cmd.parser.book.Identifiers); err != nil {
+			fmt.Fprintf(os.Stderr, "error while saving identifiers: %v\n", err)
+			return
+		}
+		if err := cmd.parser.lib.SetReading(cmd.parser.book.ID, cmd.parser.book.Reading); err != nil {
+			fmt.Fprintf(os.Stderr, "error while saving reading information: %v\n", err)
+			return
+		}
 		err := cmd.parser.lib.UpdateBook(*cmd.parser.book, true)
 		if bee, ok := err.(books.BookExistsError); ok {
 			if args == "-m" {
@@ -131,6 +542,10 @@ var saveCmd = &DefaultCommand{
 					return
 				}
 				fmt.Printf("Merged into %d\n", bee.BookID)
+				if err := cmd.parser.reload(bee.BookID); err != nil {
+					fmt.Fprintf(os.Stderr, "Error reloading book: %v\n", err)
+					return
+				}
 			} else {
 				fmt.Printf("A duplicate book already exists, id: %d. To merge, type save -m.\n", bee.BookID)
 				return
@@ -139,6 +554,10 @@ var saveCmd = &DefaultCommand{
 			fmt.Fprintf(os.Stderr, "error while updating book: %v\n", err)
 			return
 		}
+		cmd.parser.renameFiles(cmd.parser.book.ID)
+		if err := cmd.parser.reload(cmd.parser.book.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reloading book: %v\n", err)
+		}
 	},
 	completer: func(cmd *DefaultCommand, s string) []string {
 		if !strings.HasPrefix("save", s) {
@@ -148,12 +567,110 @@ var saveCmd = &DefaultCommand{
 	},
 }
 
+var splitCmd = &DefaultCommand{
+	Help: "Moves a file into another book, or into a new book with the given title and this book's authors and series",
+	Run: func(cmd *DefaultCommand, args string) {
+		fields := strings.SplitN(strings.TrimSpace(args), " ", 2)
+		if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			fmt.Fprintf(os.Stderr, "Usage: split <file id> <book id or new title>\n")
+			return
+		}
+		fileID, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid file ID.\n")
+			return
+		}
+		found := false
+		for _, f := range cmd.parser.book.Files {
+			if f.ID == fileID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "File %d doesn't belong to this book.\n", fileID)
+			return
+		}
+		// The book is reloaded after splitting, so unsaved changes would be lost.
+		if unsaved, err := cmd.parser.hasUnsavedChanges(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting book: %v\n", err)
+			return
+		} else if unsaved {
+			fmt.Fprintf(os.Stderr, "This book has unsaved changes. Save them before splitting a file out of it.\n")
+			return
+		}
+
+		// Without unsaved changes, the edited book is the one stored in the library.
+		newBook := books.Book{Authors: cmd.parser.book.Authors, Series: cmd.parser.book.Series}
+		if id, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64); err == nil {
+			newBook.ID = id
+		} else {
+			newBook.Title = strings.TrimSpace(fields[1])
+		}
+		oldBookID := cmd.parser.book.ID
+		id, err := cmd.parser.lib.SplitFile(fileID, newBook)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error splitting file: %v\n", err)
+			return
+		}
+		fmt.Printf("Moved file %d to book %d\n", fileID, id)
+		cmd.parser.renameFiles(id)
+
+		// Keep editing the original book, unless it was removed because it has no files left.
+		if err := cmd.parser.reload(oldBookID); err != nil {
+			fmt.Printf("Book %d has no files left and was removed; now editing book %d\n", oldBookID, id)
+			if err := cmd.parser.reload(id); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reloading book: %v\n", err)
+			}
+		}
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("split", s) {
+			return []string{}
+		}
+		return []string{"split "}
+	},
+}
+
 var showCmd = &DefaultCommand{
 	Help: "Shows available commands",
 	Run: func(cmd *DefaultCommand, args string) {
 		fmt.Println("Title: ", cmd.parser.book.Title)
 		fmt.Println("Authors: ", strings.Join(cmd.parser.book.Authors, " & "))
 		fmt.Println("Series: ", cmd.parser.book.Series)
+		if cmd.parser.book.SeriesIndex != 0 {
+			fmt.Println("Series index: ", strconv.FormatFloat(cmd.parser.book.SeriesIndex, 'f', -1, 64))
+		}
+		if len(cmd.parser.book.Identifiers) > 0 {
+			fmt.Println("Identifiers: ", joinIdentifiers(cmd.parser.book.Identifiers))
+		}
+		r := cmd.parser.book.Reading
+		fmt.Println("Status: ", r.Status.Description())
+		if r.Started != nil {
+			fmt.Println("Started: ", r.Started.Format("2006-01-02"))
+		}
+		if r.Finished != nil {
+			fmt.Println("Finished: ", r.Finished.Format("2006-01-02"))
+		}
+		if r.Rating > 0 {
+			fmt.Printf("Rating:  %d/%d\n", r.Rating, books.MaxRating)
+		}
+		if r.Notes != "" {
+			fmt.Println("Notes: ", r.Notes)
+		}
+		for _, f := range cmd.parser.lib.CustomFields() {
+			if value, ok := cmd.parser.book.Fields[f.Name]; ok {
+				fmt.Printf("%s:  %s\n", f.Name, value)
+			}
+		}
+		fmt.Println("Files:")
+		for _, f := range cmd.parser.book.Files {
+			if len(f.Tags) > 0 {
+				fmt.Printf("  %d: %s (%s)\n", f.ID, f.Extension, strings.Join(f.Tags, ", "))
+			} else {
+				fmt.Printf("  %d: %s\n", f.ID, f.Extension)
+			}
+		}
 	},
 	completer: func(cmd *DefaultCommand, s string) []string {
 		if !strings.HasPrefix("show", s) {
@@ -197,10 +714,12 @@ var quitCmd = &DefaultCommand{
 }
 
 // NewParser creates a new parser.
-func NewParser(book *books.Book, lib *books.Library) *Parser {
+// Files are renamed according to outputTmpl whenever the book is saved.
+func NewParser(book *books.Book, lib *books.Library, outputTmpl *template.Template) *Parser {
 	parser := &Parser{
-		book: book,
-		lib:  lib,
+		book:       book,
+		lib:        lib,
+		outputTmpl: outputTmpl,
 	}
 
 	// Return a copy of a DefaultCommand  with a parser and completer added.
@@ -217,8 +736,19 @@ func NewParser(book *books.Book, lib *books.Library) *Parser {
 	m["authors"] = c(authorsCmd)
 	m["title"] = c(titleCmd)
 	m["series"] = c(seriesCmd)
+	m["seriesindex"] = c(seriesIndexCmd)
+	m["status"] = c(statusCmd)
+	m["started"] = c(startedCmd)
+	m["finished"] = c(finishedCmd)
+	m["rating"] = c(ratingCmd)
+	m["notes"] = c(notesCmd)
+	m["identifiers"] = c(identifiersCmd)
+	m["field"] = c(fieldCmd)
+	m["addtag"] = c(addTagCmd)
+	m["rmtag"] = c(rmTagCmd)
 	m["save"] = c(saveCmd)
 	m["show"] = c(showCmd)
+	m["split"] = c(splitCmd)
 	m["help"] = c(helpCmd)
 	m["quit"] = c(quitCmd)
 	parser.commands = m
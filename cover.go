@@ -0,0 +1,378 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the GIF decoder for covers.
+	"image/jpeg"
+	_ "image/png" // Register the PNG decoder for covers.
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+)
+
+// The largest width and height of a cover thumbnail. Thumbnails keep the cover's aspect ratio.
+const (
+	ThumbnailWidth  = 120
+	ThumbnailHeight = 180
+)
+
+// maxCoverSize is the largest cover image that will be read from a file.
+const maxCoverSize = 20 << 20
+
+// maxCoverPixels is the largest number of pixels a cover can have, since making its thumbnail decodes the whole image.
+const maxCoverPixels = 40 << 20
+
+// coverExts are the extensions of cover images which can be stored next to a book file,
+// named after the file, as in Title.jpg for Title.epub, or named cover, as in cover.jpg.
+var coverExts = []string{".jpg", ".jpeg", ".png"}
+
+// havePdftoppm is set once pdftoppm, which renders the first page of a PDF as its cover, is found to be installed.
+var (
+	pdftoppmOnce sync.Once
+	havePdftoppm bool
+)
+
+// coversDir returns the directory covers are stored in, next to the conversion cache.
+// Covers are named after the SHA-256 hash of their contents, so books with the same cover share a file.
+func (lib *Library) coversDir() string {
+	return path.Join(path.Dir(lib.filename), "covers")
+}
+
+// CoverFilename returns the full path of a book's cover, given its Cover field.
+func (lib *Library) CoverFilename(cover string) string {
+	return path.Join(lib.coversDir(), path.Base(cover))
+}
+
+// ThumbnailFilename returns the full path of the thumbnail of a book's cover, given its Cover field.
+// If the thumbnail is missing, it is created again from the cover.
+func (lib *Library) ThumbnailFilename(cover string) (string, error) {
+	fn := path.Join(lib.coversDir(), thumbnailName(path.Base(cover)))
+	if _, err := os.Stat(fn); err == nil {
+		return fn, nil
+	}
+	data, err := ioutil.ReadFile(lib.CoverFilename(cover))
+	if err != nil {
+		return "", errors.Wrap(err, "read cover")
+	}
+	if err := writeThumbnail(data, fn); err != nil {
+		return "", err
+	}
+	return fn, nil
+}
+
+// thumbnailName returns the file name of the thumbnail for a cover.
+func thumbnailName(cover string) string {
+	return strings.TrimSuffix(cover, path.Ext(cover)) + "-thumb.jpg"
+}
+
+// GetBookIDsWithoutCover returns the IDs of books which don't have a cover, in order.
+func (lib *Library) GetBookIDsWithoutCover() ([]int64, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	ids, err := queryIDs(tx, "select id from books where cover='' order by id")
+	return ids, errors.Wrap(err, "get books without covers")
+}
+
+// UpdateCover extracts a cover from the files of a book which doesn't have one, and returns true if one was found.
+// Files are tried in order, with the same sources as ImportBook.
+func (lib *Library) UpdateCover(bookID int64) (bool, error) {
+	bks, err := lib.GetBooksByID([]int64{bookID})
+	if err != nil {
+		return false, errors.Wrap(err, "update cover")
+	}
+	if len(bks) == 0 {
+		return false, errors.Errorf("book %d not found", bookID)
+	}
+	if bks[0].Cover != "" {
+		return false, nil
+	}
+	for _, bf := range bks[0].Files {
+		cover := lib.extractCover(path.Join(lib.booksRoot, bf.CurrentFilename))
+		if cover == "" {
+			continue
+		}
+		if _, err := lib.Exec("update books set updated_on=datetime(), cover=? where id=? and cover=''", cover, bookID); err != nil {
+			return false, errors.Wrap(err, "update cover")
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// extractCover finds a cover for a book file, stores it in the covers directory, and returns its name.
+// The cover is taken from the EPUB manifest, an image next to the file as described in sidecarCover,
+// or the first page of a PDF, in that order.
+// It returns an empty string if there is no cover; errors are only logged, since a missing cover shouldn't stop an import.
+func (lib *Library) extractCover(filename string) string {
+	return lib.storeFoundCover(filename, findCover(filename))
+}
+
+// findCover reads the cover of a book file without storing it, as described in extractCover.
+// It returns nil if there is no cover or it can't be read.
+func findCover(filename string) []byte {
+	data, err := readCover(filename)
+	if err != nil {
+		log.Printf("Error extracting cover from %s: %s", filename, err)
+		return nil
+	}
+	return data
+}
+
+// storeFoundCover stores a cover returned by findCover for filename, and returns its name.
+// It returns an empty string if data is nil or can't be stored.
+func (lib *Library) storeFoundCover(filename string, data []byte) string {
+	if data == nil {
+		return ""
+	}
+	cover, err := lib.storeCover(data)
+	if err != nil {
+		log.Printf("Error storing cover from %s: %s", filename, err)
+		return ""
+	}
+	return cover
+}
+
+// readCover returns the cover image for a book file, or nil if none was found.
+func readCover(filename string) ([]byte, error) {
+	ext := strings.ToLower(path.Ext(filename))
+	if ext == ".epub" {
+		data, err := epubCover(filename)
+		if data != nil || err != nil {
+			return data, err
+		}
+	}
+	if data, err := sidecarCover(filename); data != nil || err != nil {
+		return data, err
+	}
+	if ext == ".pdf" {
+		return pdfCover(filename)
+	}
+	return nil, nil
+}
+
+// sidecarCover reads the cover image stored next to a book file, or returns nil if there isn't one.
+// An image named after the file is used first. One named cover is only used if the directory holds a single book,
+// since in a directory of many books it's not known which of them it belongs to.
+func sidecarCover(filename string) ([]byte, error) {
+	dir := path.Dir(filename)
+	base := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	for _, name := range []string{base, "cover"} {
+		for _, ext := range coverExts {
+			fn := path.Join(dir, name+ext)
+			fi, err := os.Stat(fn)
+			if err != nil || !fi.Mode().IsRegular() || fi.Size() > maxCoverSize {
+				continue
+			}
+			if name == "cover" && !isSingleBookDir(dir, base) {
+				return nil, nil
+			}
+			return ioutil.ReadFile(fn)
+		}
+	}
+	return nil, nil
+}
+
+// isSingleBookDir returns true if every book file in dir is named base, with any extension, so they're all formats of one book.
+// Images, OPF metadata and hidden files aren't books.
+func isSingleBookDir(dir, base string) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		switch strings.ToLower(path.Ext(name)) {
+		case ".jpg", ".jpeg", ".png", ".gif", ".opf":
+			continue
+		}
+		if !fi.Mode().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if strings.TrimSuffix(name, path.Ext(name)) != base {
+			return false
+		}
+	}
+	return true
+}
+
+// epubCover reads the cover image named in an EPUB's manifest.
+// EPUB 3 marks the cover with the cover-image property; EPUB 2 names its manifest item in a cover meta tag.
+// Failing those, an image item whose ID or path contains "cover" is used.
+func epubCover(filename string) ([]byte, error) {
+	f, err := epub.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var coverID string
+	for _, meta := range f.Opf.Metadata.Meta {
+		if meta.Name == "cover" {
+			coverID = meta.Content
+		}
+	}
+	var href string
+	for _, item := range f.Opf.Manifest {
+		if strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			href = item.Href
+			break
+		}
+		if !strings.HasPrefix(item.MediaType, "image/") {
+			continue
+		}
+		if coverID != "" && (item.ID == coverID || item.Href == coverID) {
+			href = item.Href
+		} else if href == "" && (strings.Contains(strings.ToLower(item.ID), "cover") || strings.Contains(strings.ToLower(item.Href), "cover")) {
+			href = item.Href
+		}
+	}
+	if href == "" {
+		return nil, nil
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	r, err := f.Open(href)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", href)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxCoverSize+1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", href)
+	}
+	if len(data) > maxCoverSize {
+		return nil, errors.Errorf("%s is too large", href)
+	}
+	return data, nil
+}
+
+// pdfCover renders the first page of a PDF with pdftoppm, or returns nil if pdftoppm isn't installed.
+func pdfCover(filename string) ([]byte, error) {
+	pdftoppmOnce.Do(func() {
+		_, err := exec.LookPath("pdftoppm")
+		havePdftoppm = err == nil
+		if !havePdftoppm {
+			log.Printf("pdftoppm not found; PDF covers won't be extracted")
+		}
+	})
+	if !havePdftoppm {
+		return nil, nil
+	}
+	dir, err := ioutil.TempDir("", "books-cover")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := path.Join(dir, "cover")
+	cmd := exec.Command("pdftoppm", "-f", "1", "-l", "1", "-singlefile", "-jpeg", "-scale-to", "1200", filename, out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, errors.Errorf("pdftoppm: %s: %s", err, bytes.TrimSpace(output))
+	}
+	return ioutil.ReadFile(out + ".jpg")
+}
+
+// storeCover checks that data is an image, and writes it and its thumbnail to the covers directory if they aren't there already.
+func (lib *Library) storeCover(data []byte) (string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Wrap(err, "decode cover")
+	}
+	if config.Width*config.Height > maxCoverPixels {
+		return "", errors.Errorf("cover is too large: %dx%d", config.Width, config.Height)
+	}
+	ext := "." + format
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	cover := fmt.Sprintf("%x", sha256.Sum256(data)) + ext
+
+	if err := os.MkdirAll(lib.coversDir(), 0755); err != nil {
+		return "", errors.Wrap(err, "create covers directory")
+	}
+	fn := lib.CoverFilename(cover)
+	if _, err := os.Stat(fn); err == nil {
+		return cover, nil
+	}
+	if err := writeThumbnail(data, path.Join(lib.coversDir(), thumbnailName(cover))); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+		return "", errors.Wrap(err, "write cover")
+	}
+	return cover, nil
+}
+
+// writeThumbnail scales a cover down to fit in ThumbnailWidth by ThumbnailHeight, and writes it to fn as a JPEG.
+func writeThumbnail(data []byte, fn string) error {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "decode cover")
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail(img, ThumbnailWidth, ThumbnailHeight), &jpeg.Options{Quality: 85}); err != nil {
+		return errors.Wrap(err, "encode thumbnail")
+	}
+	return errors.Wrap(ioutil.WriteFile(fn, buf.Bytes(), 0644), "write thumbnail")
+}
+
+// thumbnail scales img to fit in width by height, keeping its aspect ratio.
+// Each pixel of the thumbnail is the average of the pixels it covers in img, over a white background since JPEGs can't be transparent.
+// Images which already fit aren't enlarged.
+func thumbnail(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= width && h <= height {
+		return img
+	}
+	if w*height > h*width {
+		height = h * width / w
+	} else {
+		width = w * height / h
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*h/height, b.Min.Y+(y+1)*h/height
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*w/width, b.Min.X+(x+1)*w/width
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			// Colors are premultiplied by alpha, so adding the missing alpha composites them over white.
+			white := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{uint16(r/n + white), uint16(g/n + white), uint16(bl/n + white), 0xffff})
+		}
+	}
+	return dst
+}
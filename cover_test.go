@@ -0,0 +1,82 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestImportCoverAfterCommit(t *testing.T) {
+	lib, dir := newTestLibrary(t)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 3))); err != nil {
+		t.Fatal(err)
+	}
+	src := path.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	fn := path.Join(src, "Wizards First Rule.txt")
+	if err := ioutil.WriteFile(fn, []byte("wizards"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(src, "Wizards First Rule.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bf := BookFile{
+		OriginalFilename: fn,
+		CurrentFilename:  "Terry Goodkind/Wizards First Rule.txt",
+		Extension:        "txt",
+		FileSize:         7,
+		FileMtime:        time.Now(),
+	}
+	if err := bf.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	book := Book{Title: "Wizards First Rule", Authors: []string{"Terry Goodkind"}, Files: []BookFile{bf}}
+
+	// A file in place of the author's directory makes moving the book fail, rolling back the import.
+	blocker := path.Join(dir, "root", "Terry Goodkind")
+	if err := os.MkdirAll(path.Dir(blocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.ImportBook(book, true); err == nil {
+		t.Fatal("import succeeded with the author's directory blocked")
+	}
+	if covers, _ := ioutil.ReadDir(lib.coversDir()); len(covers) != 0 {
+		t.Errorf("covers left after a failed import: %d files", len(covers))
+	}
+	if n := countRows(t, lib, "select count(*) from books"); n != 0 {
+		t.Errorf("books after a failed import: %d", n)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	id, err := lib.ImportBook(book, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bks, err := lib.GetBooksByID([]int64{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bks) != 1 || bks[0].Cover == "" {
+		t.Fatalf("imported book has no cover: %+v", bks)
+	}
+	if _, err := os.Stat(lib.CoverFilename(bks[0].Cover)); err != nil {
+		t.Error(err)
+	}
+}
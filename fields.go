@@ -0,0 +1,243 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FieldType is the type of the values of a custom field.
+type FieldType string
+
+// Custom field types.
+const (
+	FieldText FieldType = "text"
+	FieldInt  FieldType = "int"
+	FieldBool FieldType = "bool"
+	FieldDate FieldType = "date" // YYYY-MM-DD
+	FieldEnum FieldType = "enum" // One of the field's Values.
+)
+
+// A CustomField is a field declared by the user, such as a translator or edition, which books can have a value for.
+// Values are stored as text, in the form returned by Normalize.
+type CustomField struct {
+	Name string
+	Type FieldType
+	// Values holds the allowed values of an enum field.
+	Values []string
+}
+
+// Normalize checks that value is valid for the field, and returns it in the form it's stored in:
+// ints in decimal, bools as true or false, dates as YYYY-MM-DD, and enum values as they were declared.
+// Enum values are matched ignoring case.
+func (f CustomField) Normalize(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch f.Type {
+	case FieldText:
+		return value, nil
+	case FieldInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", errors.Errorf("invalid value %s for %s; use a whole number", value, f.Name)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case FieldBool:
+		switch strings.ToLower(value) {
+		case "true", "yes", "y", "1":
+			return "true", nil
+		case "false", "no", "n", "0":
+			return "false", nil
+		}
+		return "", errors.Errorf("invalid value %s for %s; use true or false", value, f.Name)
+	case FieldDate:
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return "", errors.Errorf("invalid date %s for %s; use YYYY-MM-DD", value, f.Name)
+		}
+		return t.Format("2006-01-02"), nil
+	case FieldEnum:
+		for _, v := range f.Values {
+			if strings.EqualFold(v, value) {
+				return v, nil
+			}
+		}
+		return "", errors.Errorf("invalid value %s for %s; use one of %s", value, f.Name, strings.Join(f.Values, ", "))
+	}
+	return "", errors.Errorf("unknown type %s for %s", f.Type, f.Name)
+}
+
+// SetCustomFields sets the custom fields books in the library can have, which are usually declared in the config file.
+// Field names are made of lowercase letters, digits and underscores, starting with a letter,
+// so they can be searched for as name:value and used in templates as .Fields.name.
+// They must not be the name of a field which can already be searched.
+func (lib *Library) SetCustomFields(fields []CustomField) error {
+	declared := make([]CustomField, 0, len(fields))
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		f.Name = strings.ToLower(strings.TrimSpace(f.Name))
+		f.Type = FieldType(strings.ToLower(string(f.Type)))
+		if f.Name == "" || strings.IndexFunc(f.Name, func(r rune) bool { return !isFieldChar(r) }) >= 0 || f.Name[0] < 'a' || f.Name[0] > 'z' {
+			return errors.Errorf("invalid custom field name %q; use letters, digits and underscores, starting with a letter", f.Name)
+		}
+		if _, ok := predicates[f.Name]; ok || isFTSColumn(f.Name) || f.Name == "sort" {
+			return errors.Errorf("custom field %s has the same name as a built in field", f.Name)
+		}
+		if seen[f.Name] {
+			return errors.Errorf("custom field %s is declared more than once", f.Name)
+		}
+		seen[f.Name] = true
+		switch f.Type {
+		case FieldText, FieldInt, FieldBool, FieldDate:
+		case FieldEnum:
+			if len(f.Values) == 0 {
+				return errors.Errorf("enum field %s has no values", f.Name)
+			}
+		default:
+			return errors.Errorf("custom field %s has unknown type %q; use text, int, bool, date or enum", f.Name, f.Type)
+		}
+		declared = append(declared, f)
+	}
+	lib.fields = declared
+	return nil
+}
+
+// CustomFields returns the custom fields set with SetCustomFields, in the order they were declared.
+func (lib *Library) CustomFields() []CustomField {
+	return lib.fields
+}
+
+// findCustomField returns the field in fields with the given name.
+func findCustomField(fields []CustomField, name string) (CustomField, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return CustomField{}, false
+}
+
+// SetFields sets the values of a book's custom fields. Fields with an empty value, or which aren't in values, are cleared.
+// Values of fields which aren't declared with SetCustomFields are ignored, and any stored values for them are kept,
+// so a field which is removed from the config file doesn't lose its values.
+func (lib *Library) SetFields(bookID int64, values map[string]string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "set fields")
+	}
+	defer tx.Rollback()
+
+	for _, f := range lib.fields {
+		value := strings.TrimSpace(values[f.Name])
+		if value == "" {
+			if _, err := tx.Exec("delete from custom_fields where book_id=? and name=?", bookID, f.Name); err != nil {
+				return errors.Wrap(err, "set fields")
+			}
+			continue
+		}
+		value, err := f.Normalize(value)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("update custom_fields set updated_on=datetime(), value=? where book_id=? and name=? and value!=?", value, bookID, f.Name, value)
+		if err != nil {
+			return errors.Wrap(err, "set fields")
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return errors.Wrap(err, "set fields")
+		} else if n == 0 {
+			if _, err := tx.Exec("insert or ignore into custom_fields (book_id, name, value) values (?, ?, ?)", bookID, f.Name, value); err != nil {
+				return errors.Wrap(err, "set fields")
+			}
+		}
+	}
+	return errors.Wrap(tx.Commit(), "set fields")
+}
+
+// getFieldsByBookIDs gets the values of the custom fields of each book.
+func getFieldsByBookIDs(tx *sql.Tx, ids []int64) (map[int64]map[string]string, error) {
+	m := make(map[int64]map[string]string)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select book_id, name, value from custom_fields where book_id in (" + joinInt64s(ids, ",") + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var name, value string
+		if err := rows.Scan(&bookID, &name, &value); err != nil {
+			return nil, err
+		}
+		if m[bookID] == nil {
+			m[bookID] = make(map[string]string)
+		}
+		m[bookID][name] = value
+	}
+	return m, rows.Err()
+}
+
+// mergeFields fills in the custom fields the first book doesn't have from the other books with the lowest IDs that have them.
+func mergeFields(tx *sql.Tx, ids []int64) error {
+	others := joinInt64s(ids[1:], ",")
+	_, err := tx.Exec("insert or ignore into custom_fields (book_id, name, value) select ?, name, value from custom_fields where book_id in ("+others+") order by book_id", ids[0])
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("delete from custom_fields where book_id in (" + others + ")")
+	return err
+}
+
+// customFieldPredicate returns a predicate which matches books by the value of a custom field.
+// Text fields match values ignoring case, or values starting with the term's value if it ends in *.
+// Int and date fields can be compared, and dates can be a year, month, or day, as in addedPredicate.
+// bool fields match books without a value as false.
+func customFieldPredicate(f CustomField) predicate {
+	return func(t Term) (string, []interface{}, error) {
+		const exists = "exists (select 1 from custom_fields cf where cf.book_id = b.id and cf.name = ? and "
+		args := []interface{}{f.Name}
+		if t.Op != OpMatch && t.Op != OpEq && f.Type != FieldInt && f.Type != FieldDate {
+			return "", nil, queryErrorf("%s can't be compared with %s", f.Name, t.Op)
+		}
+		switch f.Type {
+		case FieldText:
+			if t.Prefix {
+				return exists + "cf.value like ? escape '\\')", append(args, strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(t.Value)+"%"), nil
+			}
+			return exists + "cf.value = ? collate nocase)", append(args, t.Value), nil
+		case FieldInt:
+			n, err := strconv.ParseInt(strings.TrimSpace(t.Value), 10, 64)
+			if err != nil {
+				return "", nil, queryErrorf("invalid value %s for %s; use a whole number", t.Value, f.Name)
+			}
+			return exists + "cast(cf.value as integer) " + sqlOp(t.Op) + " ?)", append(args, n), nil
+		case FieldDate:
+			start, end, err := parseDateRange(t.Value)
+			if err != nil {
+				return "", nil, err
+			}
+			expr, rangeArgs, err := compareRange("(cf.value || ' 00:00:00')", t.Op, start, end)
+			if err != nil {
+				return "", nil, err
+			}
+			return exists + expr + ")", append(args, rangeArgs...), nil
+		}
+		value, err := f.Normalize(t.Value)
+		if err != nil {
+			return "", nil, queryErrorf("%s", err)
+		}
+		if value == "false" && f.Type == FieldBool {
+			return "not " + exists + "cf.value = 'true')", args, nil
+		}
+		return exists + "cf.value = ?)", append(args, value), nil
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProblemKind identifies the kind of problem found by Check.
+type ProblemKind string
+
+// Kinds of problems found by Check.
+const (
+	MissingFile        ProblemKind = "missing file"
+	SizeMismatch       ProblemKind = "size mismatch"
+	HashMismatch       ProblemKind = "hash mismatch"
+	UnknownFile        ProblemKind = "unknown file"
+	FileWithoutBook    ProblemKind = "file without book"
+	BookWithoutFiles   ProblemKind = "book without files"
+	MissingSearchRow   ProblemKind = "missing search row"
+	OrphanedSearchRow  ProblemKind = "orphaned search row"
+	DanglingAuthorLink ProblemKind = "dangling author link"
+	DanglingTagLink    ProblemKind = "dangling tag link"
+	UnusedAuthor       ProblemKind = "unused author"
+	UnusedTag          ProblemKind = "unused tag"
+)
+
+// A Problem is an inconsistency between the library and itself, or between the library and the books root.
+// ID holds the ID of the row the problem refers to, such as a book, file, or link between them.
+// Path is set for problems concerning files on disk, and is relative to the books root.
+type Problem struct {
+	Kind   ProblemKind `json:"kind"`
+	ID     int64       `json:"id"`
+	Path   string      `json:"path"`
+	Detail string      `json:"detail"`
+}
+
+func (p Problem) String() string {
+	s := string(p.Kind)
+	if p.ID != 0 {
+		s += fmt.Sprintf(" (%d)", p.ID)
+	}
+	if p.Path != "" {
+		s += ": " + p.Path
+	}
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+// Repairable returns true if Repair can safely fix the problem.
+// Problems with files on disk are never repaired automatically, since the file is the only copy of the book.
+// Books without files are only reported, since they may be kept on purpose, such as to stay on a shelf or in a want to read list,
+// and deleting them would lose their reading status, identifiers and other information.
+func (p Problem) Repairable() bool {
+	switch p.Kind {
+	case MissingSearchRow, OrphanedSearchRow, DanglingAuthorLink, DanglingTagLink, UnusedAuthor, UnusedTag:
+		return true
+	}
+	return false
+}
+
+// Check looks for problems in the library.
+// Every file is checked to make sure it exists with the recorded size, and if checkHashes is true, with the recorded hash.
+// The books root is also checked for files the library doesn't know about.
+func (lib *Library) Check(checkHashes bool) ([]Problem, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "get transaction")
+	}
+	defer tx.Rollback()
+
+	problems := []Problem{}
+	queries := []struct {
+		kind  ProblemKind
+		query string
+	}{
+		{FileWithoutBook, "select id from files where book_id not in (select id from books)"},
+		{BookWithoutFiles, "select id from books where id not in (select book_id from files)"},
+		{MissingSearchRow, "select id from books where id not in (select rowid from books_fts)"},
+		{OrphanedSearchRow, "select rowid from books_fts where rowid not in (select id from books)"},
+		{DanglingAuthorLink, "select id from books_authors where book_id not in (select id from books) or author_id not in (select id from authors)"},
+		{DanglingTagLink, "select id from files_tags where file_id not in (select id from files) or tag_id not in (select id from tags)"},
+		{UnusedAuthor, "select id from authors where id not in (select author_id from books_authors) and id not in (select author_id from author_aliases)"},
+		{UnusedTag, "select id from tags where id not in (select tag_id from files_tags)"},
+	}
+	for _, q := range queries {
+		ids, err := queryIDs(tx, q.query)
+		if err != nil {
+			return nil, errors.Wrapf(err, "check for %s", q.kind)
+		}
+		for _, id := range ids {
+			problems = append(problems, Problem{Kind: q.kind, ID: id})
+		}
+	}
+
+	fileProblems, known, err := lib.checkFiles(tx, checkHashes)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, fileProblems...)
+
+	unknown, err := lib.findUnknownFiles(known)
+	if err != nil {
+		return nil, errors.Wrap(err, "find unknown files")
+	}
+	problems = append(problems, unknown...)
+	return problems, nil
+}
+
+// checkFiles checks that every file in the library exists in the books root with the recorded size and hash.
+// It also returns the set of filenames the library knows about.
+func (lib *Library) checkFiles(tx *sql.Tx, checkHashes bool) ([]Problem, map[string]bool, error) {
+	problems := []Problem{}
+	known := make(map[string]bool)
+	rows, err := tx.Query("select id, filename, file_size, hash from files order by id")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get files")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bf BookFile
+		if err := rows.Scan(&bf.ID, &bf.CurrentFilename, &bf.FileSize, &bf.Hash); err != nil {
+			return nil, nil, errors.Wrap(err, "get files")
+		}
+		known[bf.CurrentFilename] = true
+
+		fn := path.Join(lib.booksRoot, bf.CurrentFilename)
+		fi, err := os.Stat(fn)
+		if os.IsNotExist(err) {
+			problems = append(problems, Problem{Kind: MissingFile, ID: bf.ID, Path: bf.CurrentFilename})
+			continue
+		} else if err != nil {
+			problems = append(problems, Problem{Kind: MissingFile, ID: bf.ID, Path: bf.CurrentFilename, Detail: err.Error()})
+			continue
+		}
+		if fi.Size() != bf.FileSize {
+			problems = append(problems, Problem{Kind: SizeMismatch, ID: bf.ID, Path: bf.CurrentFilename,
+				Detail: fmt.Sprintf("expected %d bytes, found %d", bf.FileSize, fi.Size())})
+		}
+		if !checkHashes {
+			continue
+		}
+		actual := BookFile{OriginalFilename: fn}
+		if err := actual.CalculateHash(); err != nil {
+			problems = append(problems, Problem{Kind: HashMismatch, ID: bf.ID, Path: bf.CurrentFilename, Detail: err.Error()})
+		} else if actual.Hash != bf.Hash {
+			problems = append(problems, Problem{Kind: HashMismatch, ID: bf.ID, Path: bf.CurrentFilename,
+				Detail: fmt.Sprintf("expected %s, found %s", bf.Hash, actual.Hash)})
+		}
+	}
+	return problems, known, rows.Err()
+}
+
+// findUnknownFiles returns a problem for each file in the books root which isn't in known.
+func (lib *Library) findUnknownFiles(known map[string]bool) ([]Problem, error) {
+	problems := []Problem{}
+	if _, err := os.Stat(lib.booksRoot); os.IsNotExist(err) {
+		return problems, nil
+	}
+	err := filepath.Walk(lib.booksRoot, func(fn string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(lib.booksRoot, fn)
+		if err != nil {
+			return err
+		}
+		rel = strings.Replace(rel, string(filepath.Separator), "/", -1)
+		if !known[rel] {
+			problems = append(problems, Problem{Kind: UnknownFile, Path: rel})
+		}
+		return nil
+	})
+	return problems, err
+}
+
+// Repair fixes each repairable problem in problems, and returns the problems that were repaired.
+// All repairs are made in a single transaction.
+func (lib *Library) Repair(problems []Problem) ([]Problem, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "get transaction")
+	}
+
+	byKind := make(map[ProblemKind][]int64)
+	repaired := []Problem{}
+	for _, p := range problems {
+		if p.Repairable() {
+			byKind[p.Kind] = append(byKind[p.Kind], p.ID)
+			repaired = append(repaired, p)
+		}
+	}
+
+	if ids := byKind[DanglingAuthorLink]; len(ids) > 0 {
+		if _, err := tx.Exec("delete from books_authors where id in (" + joinInt64s(ids, ",") + ")"); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, "delete dangling author links")
+		}
+	}
+	if ids := byKind[DanglingTagLink]; len(ids) > 0 {
+		if _, err := tx.Exec("delete from files_tags where id in (" + joinInt64s(ids, ",") + ")"); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, "delete dangling tag links")
+		}
+	}
+	if ids := byKind[OrphanedSearchRow]; len(ids) > 0 {
+		if _, err := tx.Exec("delete from books_fts where rowid in (" + joinInt64s(ids, ",") + ")"); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, "delete orphaned search rows")
+		}
+	}
+	for _, id := range byKind[MissingSearchRow] {
+		if err := reindexBookInSearch(tx, id); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "index book %d", id)
+		}
+	}
+	if len(byKind[UnusedAuthor]) > 0 || len(byKind[UnusedTag]) > 0 {
+		if err := deleteOrphans(tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "repair library")
+	}
+	return repaired, nil
+}
+
+// queryIDs runs a query which selects a single integer column, and returns the results.
+func queryIDs(tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	var id int64
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,90 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// problemKinds returns the sorted kinds of problems.
+func problemKinds(problems []Problem) []string {
+	kinds := []string{}
+	for _, p := range problems {
+		kinds = append(kinds, string(p.Kind))
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestRepair(t *testing.T) {
+	lib, dir := newTestLibrary(t)
+	importTestBook(t, lib, dir, "Terry Goodkind", "Wizards First Rule", "txt", "wizards")
+	importTestBook(t, lib, dir, "Stephen King", "The Shining", "txt", "shining")
+	if err := os.Remove(path.Join(dir, "root", "Stephen King", "The Shining.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "root", "unknown.txt"), []byte("unknown"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A book without files, which is on a want to read list.
+	res, err := lib.Exec("insert into books (title, series) values ('Placeholder', '')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	placeholder, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.SetReading(placeholder, Reading{Status: StatusWantToRead}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.Exec("delete from books_fts where rowid=?", placeholder); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.Exec("insert into tags (name) values ('unused')"); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := lib.Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{string(BookWithoutFiles), string(MissingFile), string(MissingSearchRow), string(UnknownFile), string(UnusedTag)}
+	if kinds := problemKinds(problems); !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("Check found %v, want %v", kinds, want)
+	}
+	repaired, err := lib.Repair(problems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{string(MissingSearchRow), string(UnusedTag)}
+	if kinds := problemKinds(repaired); !reflect.DeepEqual(kinds, want) {
+		t.Errorf("Repair repaired %v, want %v", kinds, want)
+	}
+
+	// Problems which can't be repaired safely are left alone.
+	problems, err = lib.Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{string(BookWithoutFiles), string(MissingFile), string(UnknownFile)}
+	if kinds := problemKinds(problems); !reflect.DeepEqual(kinds, want) {
+		t.Errorf("after repairing, Check found %v, want %v", kinds, want)
+	}
+	if books, err := lib.GetBooksByID([]int64{placeholder}); err != nil || len(books) != 1 || books[0].Reading.Status != StatusWantToRead {
+		t.Errorf("the book without files or its reading status was deleted: %v, %v", books, err)
+	}
+	if n := countRows(t, lib, "select count(*) from files"); n != 2 {
+		t.Errorf("%d files left, want 2", n)
+	}
+	if _, err := os.Stat(path.Join(dir, "root", "unknown.txt")); err != nil {
+		t.Errorf("the unknown file was removed: %v", err)
+	}
+}
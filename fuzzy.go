@@ -0,0 +1,342 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// maxSuggestions is the largest number of suggestions returned for a search with no results.
+const maxSuggestions = 5
+
+// fuzzyFields are the fields misspelled searches are corrected against, with queries selecting their values.
+var fuzzyFields = []struct {
+	field string
+	query string
+}{
+	{"author", "select name from authors"},
+	{"title", "select distinct title from books"},
+	{"series", "select distinct series from books where series != ''"},
+}
+
+// vocabulary holds the values of fuzzyFields, and the words they contain.
+type vocabulary struct {
+	names map[string][]string
+	// words maps each field to its words, as split by the search index.
+	// Words are keyed in lower case, and map to the first form they were seen in.
+	words map[string]map[string]string
+}
+
+// A suggestion is a query similar to one which returned no results.
+type suggestion struct {
+	query    string
+	distance int
+}
+
+// loadVocabulary reads the values of fuzzyFields from the library.
+func (lib *Library) loadVocabulary() (*vocabulary, error) {
+	v := &vocabulary{names: make(map[string][]string), words: make(map[string]map[string]string)}
+	v.words[""] = make(map[string]string)
+	for _, f := range fuzzyFields {
+		field := f.field
+		rows, err := lib.Query(f.query)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get %s names", field)
+		}
+		words := make(map[string]string)
+		var name string
+		for rows.Next() {
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, errors.Wrapf(err, "get %s names", field)
+			}
+			v.names[field] = append(v.names[field], name)
+			for _, w := range splitWords(name) {
+				lower := strings.ToLower(w)
+				if _, ok := words[lower]; !ok {
+					words[lower] = w
+				}
+				if _, ok := v.words[""][lower]; !ok {
+					v.words[""][lower] = w
+				}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, errors.Wrapf(err, "get %s names", field)
+		}
+		v.words[field] = words
+	}
+	return v, nil
+}
+
+// suggest returns queries similar to q, closest first, for when q has no results.
+// The text q searches for is compared with the authors, titles and series in the library,
+// both as a whole, which finds names whose punctuation or spelling differs slightly,
+// and word by word, which corrects misspelled words.
+func (lib *Library) suggest(q *Query) ([]string, error) {
+	hasText := false
+	for _, c := range q.Clauses {
+		for _, t := range c.Terms {
+			hasText = hasText || fuzzyTerm(t)
+		}
+	}
+	if !hasText {
+		return nil, nil
+	}
+
+	v, err := lib.loadVocabulary()
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := v.suggestNames(q)
+	if corrected, ok := v.correctWords(q); ok {
+		suggestions = append(suggestions, suggestion{query: corrected.String(), distance: 1 << 30})
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	seen := map[string]bool{q.String(): true}
+	queries := []string{}
+	for _, s := range suggestions {
+		if seen[s.query] || len(queries) == maxSuggestions {
+			continue
+		}
+		seen[s.query] = true
+		queries = append(queries, s.query)
+	}
+	return queries, nil
+}
+
+// fuzzyTerm returns true if t is text which can be corrected against the vocabulary.
+func fuzzyTerm(t Term) bool {
+	if t.Negated || t.Prefix || t.Op != OpMatch {
+		return false
+	}
+	if t.Field == "" {
+		return true
+	}
+	for _, f := range fuzzyFields {
+		if f.field == t.Field {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestNames returns queries where the text q searches for is replaced with a similar name from the library.
+// Text searched for in any field is joined and compared as a whole, while text limited to a field is compared with that field.
+func (v *vocabulary) suggestNames(q *Query) []suggestion {
+	var suggestions []suggestion
+	var textClauses []int
+	var text []string
+	for i, c := range q.Clauses {
+		if len(c.Terms) != 1 || !fuzzyTerm(c.Terms[0]) {
+			continue
+		}
+		t := c.Terms[0]
+		if t.Field == "" {
+			textClauses = append(textClauses, i)
+			text = append(text, t.Value)
+			continue
+		}
+		for _, m := range closestNames(t.Value, v.names[t.Field]) {
+			nq := *q
+			nq.Clauses = append([]Clause{}, q.Clauses...)
+			nt := t
+			nt.Value, nt.Phrase = m.query, true
+			nq.Clauses[i] = Clause{[]Term{nt}}
+			suggestions = append(suggestions, suggestion{nq.String(), m.distance})
+		}
+	}
+	if len(text) == 0 {
+		return suggestions
+	}
+
+	for _, f := range fuzzyFields {
+		field := f.field
+		for _, m := range closestNames(strings.Join(text, " "), v.names[field]) {
+			nq := *q
+			nq.Clauses = nil
+			for i, c := range q.Clauses {
+				if i == textClauses[0] {
+					nq.Clauses = append(nq.Clauses, Clause{[]Term{{Field: field, Value: m.query, Phrase: true}}})
+				}
+				if !containsInt(textClauses, i) {
+					nq.Clauses = append(nq.Clauses, c)
+				}
+			}
+			suggestions = append(suggestions, suggestion{nq.String(), m.distance})
+		}
+	}
+	return suggestions
+}
+
+// closestNames returns the names which are close enough to text to be suggested, with their distance from it.
+// Names are compared ignoring case and punctuation, and may differ by up to a quarter of their length.
+func closestNames(text string, names []string) []suggestion {
+	normalized := []rune(normalizeName(text))
+	maxDistance := len(normalized) / 4
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	var matches []suggestion
+	for _, name := range names {
+		n := []rune(normalizeName(name))
+		if abs(len(n)-len(normalized)) > maxDistance {
+			continue
+		}
+		if d := editDistance(normalized, n); d <= maxDistance {
+			matches = append(matches, suggestion{name, d})
+		}
+	}
+	return matches
+}
+
+// correctWords returns q with each misspelled word replaced with the closest word in the vocabulary,
+// and false if no words were corrected.
+func (v *vocabulary) correctWords(q *Query) (*Query, bool) {
+	nq := *q
+	nq.Clauses = make([]Clause, len(q.Clauses))
+	changed := false
+	for i, c := range q.Clauses {
+		nq.Clauses[i].Terms = make([]Term, len(c.Terms))
+		for j, t := range c.Terms {
+			if fuzzyTerm(t) {
+				var ok bool
+				if t.Value, ok = v.correct(t.Field, t.Value); ok {
+					changed = true
+				}
+			}
+			nq.Clauses[i].Terms[j] = t
+		}
+	}
+	return &nq, changed
+}
+
+// correct replaces each word in value which isn't in the field's vocabulary with the closest word that is,
+// and returns false if nothing was replaced.
+func (v *vocabulary) correct(field, value string) (string, bool) {
+	words := v.words[field]
+	changed := false
+	var result []rune
+	var word []rune
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		lower := strings.ToLower(string(word))
+		if c, ok := closestWord(lower, words); ok && c != lower {
+			result = append(result, []rune(words[c])...)
+			changed = true
+		} else {
+			result = append(result, word...)
+		}
+		word = word[:0]
+	}
+	for _, r := range value {
+		if isWordChar(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		result = append(result, r)
+	}
+	flush()
+	return string(result), changed
+}
+
+// closestWord returns the key in words closest to w, if it is close enough to be a misspelling.
+// Words shorter than three letters are never corrected.
+func closestWord(w string, words map[string]string) (string, bool) {
+	if _, ok := words[w]; ok {
+		return w, true
+	}
+	rw := []rune(w)
+	maxDistance := 2
+	switch {
+	case len(rw) < 3:
+		return "", false
+	case len(rw) <= 5:
+		maxDistance = 1
+	}
+
+	best, bestDistance := "", maxDistance+1
+	for candidate := range words {
+		rc := []rune(candidate)
+		if abs(len(rc)-len(rw)) >= bestDistance {
+			continue
+		}
+		// Ties are broken alphabetically, since map order is random.
+		if d := editDistance(rw, rc); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best, best != ""
+}
+
+// splitWords splits s into words the way the search index does, on anything other than letters and digits.
+func splitWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return !isWordChar(r) })
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// normalizeName converts a name to lower case, removes apostrophes, and replaces other punctuation with single spaces.
+func normalizeName(s string) string {
+	s = strings.NewReplacer("'", "", "’", "").Replace(strings.ToLower(s))
+	return strings.Join(splitWords(s), " ")
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func containsInt(list []int, n int) bool {
+	for _, i := range list {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"shining", "shinning", 1},
+		{"goodkind", "godokind", 2},
+		{"brontë", "bronte", 1},
+	}
+	for _, test := range tests {
+		if got := editDistance([]rune(test.a), []rune(test.b)); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := editDistance([]rune(test.b), []rune(test.a)); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
@@ -1,13 +1,12 @@
 module github.com/tspivey/books
 
+go 1.20
+
 require (
-	github.com/BurntSushi/toml v0.3.0 // indirect
 	github.com/abbot/go-http-auth v0.4.0
 	github.com/foomo/htpasswd v0.0.0-20180422071726-cb63c4ac0e50
-	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/mux v1.6.2
 	github.com/howeyc/gopass v0.0.0-20170109162249-bf9dde6d0d2c
-	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/kapmahc/epub v0.1.1
 	github.com/magefile/mage v1.5.0
 	github.com/mattn/go-sqlite3 v1.9.0
@@ -15,10 +14,31 @@ require (
 	github.com/peterh/liner v1.1.0
 	github.com/pkg/errors v0.8.0
 	github.com/pkg/xattr v0.3.1
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/viper v1.2.0
+)
+
+require (
+	github.com/BurntSushi/toml v0.3.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/gorilla/context v1.1.1 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/magiconair/properties v1.8.0 // indirect
+	github.com/mattn/go-runewidth v0.0.3 // indirect
+	github.com/mitchellh/mapstructure v1.0.0 // indirect
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.2.0 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.2 // indirect
 	github.com/stretchr/testify v1.2.2 // indirect
 	golang.org/x/crypto v0.0.0-20180910181607-0e37d006457b // indirect
 	golang.org/x/net v0.0.0-20180911220305-26e67e76b6c3 // indirect
+	golang.org/x/sys v0.0.0-20180906133057-8cf3aee42992 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
+	gopkg.in/yaml.v2 v2.2.1 // indirect
 )
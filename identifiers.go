@@ -0,0 +1,240 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An Identifier identifies a book in an outside catalog, such as an ISBN or ASIN.
+// An identifier belongs to at most one book in a library.
+type Identifier struct {
+	// Type is the lowercase name of the catalog, such as isbn, asin or uuid.
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// String returns the identifier in the form type:value, as accepted by ParseIdentifier.
+func (id Identifier) String() string {
+	return id.Type + ":" + id.Value
+}
+
+// ParseIdentifier parses an identifier in the form type:value, such as isbn:9780765326355, and normalizes it.
+// If there is no type but the value is a valid ISBN, the type is isbn.
+func ParseIdentifier(s string) (Identifier, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 2 {
+		return NormalizeIdentifier(parts[0], parts[1])
+	}
+	if isbn, err := NormalizeISBN(s); err == nil {
+		return Identifier{"isbn", isbn}, nil
+	}
+	return Identifier{}, errors.Errorf("invalid identifier %s; use type:value, as in isbn:9780765326355", s)
+}
+
+// ParseIdentifiers parses a comma separated list of identifiers, as accepted by ParseIdentifier.
+func ParseIdentifiers(s string) ([]Identifier, error) {
+	ids := []Identifier{}
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		id, err := ParseIdentifier(item)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// NormalizeIdentifier checks an identifier's value and puts it in a standard form, so that the same identifier always compares equal.
+// Types are lowercased, and isbn10 and isbn13 are both stored as isbn.
+// ISBNs are validated and converted to ISBN-13, and ASINs are uppercased.
+func NormalizeIdentifier(typ, value string) (Identifier, error) {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	value = strings.TrimSpace(value)
+	switch typ {
+	case "isbn", "isbn10", "isbn13", "isbn-10", "isbn-13":
+		isbn, err := NormalizeISBN(value)
+		if err != nil {
+			return Identifier{}, err
+		}
+		return Identifier{"isbn", isbn}, nil
+	case "asin", "amazon", "mobi-asin":
+		typ, value = "asin", strings.ToUpper(value)
+	}
+	if typ == "" || value == "" || strings.ContainsAny(typ, ", ") {
+		return Identifier{}, errors.Errorf("invalid identifier %s:%s", typ, value)
+	}
+	return Identifier{typ, value}, nil
+}
+
+// NormalizeISBN validates an ISBN-10 or ISBN-13, which may contain hyphens or spaces and be prefixed with urn:isbn:,
+// and returns it as an ISBN-13 made of digits only.
+func NormalizeISBN(s string) (string, error) {
+	isbn := strings.ToUpper(strings.TrimSpace(s))
+	for _, prefix := range []string{"URN:ISBN:", "ISBN:", "ISBN"} {
+		isbn = strings.TrimPrefix(isbn, prefix)
+	}
+	isbn = strings.NewReplacer("-", "", " ", "").Replace(isbn)
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			var digit int
+			switch {
+			case c >= '0' && c <= '9':
+				digit = int(c - '0')
+			case c == 'X' && i == 9:
+				digit = 10
+			default:
+				return "", errors.Errorf("invalid ISBN %s", s)
+			}
+			sum += digit * (10 - i)
+		}
+		if sum%11 != 0 {
+			return "", errors.Errorf("invalid ISBN %s: wrong check digit", s)
+		}
+		isbn13 := "978" + isbn[:9]
+		return isbn13 + strconv.Itoa(isbn13CheckDigit(isbn13)), nil
+	case 13:
+		for _, c := range isbn {
+			if c < '0' || c > '9' {
+				return "", errors.Errorf("invalid ISBN %s", s)
+			}
+		}
+		if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+			return "", errors.Errorf("invalid ISBN %s: must start with 978 or 979", s)
+		}
+		if int(isbn[12]-'0') != isbn13CheckDigit(isbn[:12]) {
+			return "", errors.Errorf("invalid ISBN %s: wrong check digit", s)
+		}
+		return isbn, nil
+	}
+	return "", errors.Errorf("invalid ISBN %s: must have 10 or 13 digits", s)
+}
+
+// isbn13CheckDigit returns the check digit for the first 12 digits of an ISBN-13.
+func isbn13CheckDigit(digits string) int {
+	sum := 0
+	for i, c := range digits[:12] {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(c-'0') * weight
+	}
+	return (10 - sum%10) % 10
+}
+
+// IdentifierExistsError is returned by SetIdentifiers when another book already has one of the identifiers.
+type IdentifierExistsError struct {
+	Identifier Identifier
+	BookID     int64
+}
+
+func (e IdentifierExistsError) Error() string {
+	return fmt.Sprintf("book %d already has the identifier %s", e.BookID, e.Identifier)
+}
+
+// SetIdentifiers replaces the identifiers of a book.
+// If another book already has one of the identifiers, the error is an IdentifierExistsError and nothing is changed.
+func (lib *Library) SetIdentifiers(bookID int64, ids []Identifier) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "set identifiers")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from identifiers where book_id=?", bookID); err != nil {
+		return errors.Wrap(err, "set identifiers")
+	}
+	for _, id := range ids {
+		otherID, found, err := getBookIDByIdentifier(tx, id)
+		if err != nil {
+			return errors.Wrap(err, "set identifiers")
+		}
+		if found {
+			return IdentifierExistsError{id, otherID}
+		}
+		if err := insertIdentifier(tx, bookID, id); err != nil {
+			return errors.Wrap(err, "set identifiers")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "set identifiers")
+}
+
+// GetBookIDByIdentifier returns the ID of the book with the given identifier, and false if there isn't one.
+func (lib *Library) GetBookIDByIdentifier(id Identifier) (int64, bool, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+	return getBookIDByIdentifier(tx, id)
+}
+
+func getBookIDByIdentifier(tx *sql.Tx, id Identifier) (int64, bool, error) {
+	var bookID int64
+	err := tx.QueryRow("select book_id from identifiers where type=? and value=?", id.Type, id.Value).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, errors.Wrap(err, "get book by identifier")
+	}
+	return bookID, true, nil
+}
+
+// getBookIDByIdentifiers returns the ID of the first book found with any of the given identifiers.
+func getBookIDByIdentifiers(tx *sql.Tx, ids []Identifier) (int64, bool, error) {
+	for _, id := range ids {
+		bookID, found, err := getBookIDByIdentifier(tx, id)
+		if err != nil || found {
+			return bookID, found, err
+		}
+	}
+	return 0, false, nil
+}
+
+// insertIdentifier adds an identifier to a book, unless the book already has it.
+func insertIdentifier(tx *sql.Tx, bookID int64, id Identifier) error {
+	_, err := tx.Exec("insert or ignore into identifiers (book_id, type, value) values (?, ?, ?)", bookID, id.Type, id.Value)
+	return err
+}
+
+// getIdentifiersByBookIDs gets the identifiers of each book ID, sorted by type and value.
+func getIdentifiersByBookIDs(tx *sql.Tx, ids []int64) (map[int64][]Identifier, error) {
+	m := make(map[int64][]Identifier)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select book_id, type, value from identifiers where book_id in (" + joinInt64s(ids, ",") + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var id Identifier
+		if err := rows.Scan(&bookID, &id.Type, &id.Value); err != nil {
+			return nil, err
+		}
+		m[bookID] = append(m[bookID], id)
+	}
+	for _, bookIDs := range m {
+		sort.Slice(bookIDs, func(i, j int) bool { return bookIDs[i].String() < bookIDs[j].String() })
+	}
+	return m, rows.Err()
+}
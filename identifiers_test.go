@@ -0,0 +1,33 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import "testing"
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"9780765326355", "9780765326355"},
+		{"978-0-7653-2635-5", "9780765326355"},
+		{"0765326353", "9780765326355"},
+		{"0-8044-2957-X", "9780804429573"},
+		{"080442957x", "9780804429573"},
+		{"ISBN 0-8044-2957-X", "9780804429573"},
+		{"urn:isbn:9780765326355", "9780765326355"},
+		{"979-10-90636-07-1", "9791090636071"},
+	}
+	for _, test := range tests {
+		got, err := NormalizeISBN(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("NormalizeISBN(%q) = %q, %v, want %q", test.s, got, err, test.want)
+		}
+	}
+	for _, s := range []string{"", "0765326354", "9780765326356", "1234567890123", "08044X2957", "X804429570", "97807653263"} {
+		if got, err := NormalizeISBN(s); err == nil {
+			t.Errorf("NormalizeISBN(%q) = %q, want an error", s, got)
+		}
+	}
+}
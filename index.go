@@ -0,0 +1,144 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ftsSchema creates the current version of the full-text search index.
+// Changing it requires a migration which rebuilds the index.
+var ftsSchema = `create virtual table books_fts using fts5 (author, series, title, extension, tags, filename, source, publisher, subject, description)`
+
+// ftsColumns holds the columns of books_fts, in order.
+var ftsColumns = []string{"author", "series", "title", "extension", "tags", "filename", "source", "publisher", "subject", "description"}
+
+// ftsWeights holds the bm25 weight of each column in books_fts, in the same order as ftsColumns.
+// Matches in titles and authors rank above matches in tags, sources and descriptions.
+const ftsWeights = "10.0, 5.0, 10.0, 1.0, 2.0, 1.0, 1.0, 2.0, 2.0, 0.5"
+
+// HighlightStart and HighlightEnd surround matching text in search snippets.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// HighlightHTML returns a search snippet as HTML, with its text escaped and each match surrounded by <mark> and </mark>.
+// Snippets are given in this form in JSON output, since HighlightStart and HighlightEnd are control characters.
+func HighlightHTML(snippet string) string {
+	s := html.EscapeString(snippet)
+	s = strings.Replace(s, HighlightStart, "<mark>", -1)
+	return strings.Replace(s, HighlightEnd, "</mark>", -1)
+}
+
+// reindexBatchSize is the number of books loaded at once while rebuilding the search index.
+const reindexBatchSize = 500
+
+// Reindex drops and rebuilds the full-text search index from the books in the library, in a single transaction.
+// If progress isn't nil, it is called after each batch of books is indexed with the number of books indexed so far, and the total.
+func (lib *Library) Reindex(progress func(done, total int)) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "get transaction")
+	}
+	if err := reindex(tx, progress); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "reindex")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "reindex")
+	}
+	return nil
+}
+
+func reindex(tx *sql.Tx, progress func(done, total int)) error {
+	if _, err := tx.Exec("drop table if exists books_fts"); err != nil {
+		return errors.Wrap(err, "drop search index")
+	}
+	if _, err := tx.Exec(ftsSchema); err != nil {
+		return errors.Wrap(err, "create search index")
+	}
+
+	ids, err := queryIDs(tx, "select id from books order by id")
+	if err != nil {
+		return errors.Wrap(err, "get book IDs")
+	}
+	for start := 0; start < len(ids); start += reindexBatchSize {
+		end := start + reindexBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		books, err := getBooksByID(tx, ids[start:end])
+		if err != nil {
+			return errors.Wrap(err, "get books")
+		}
+		for i := range books {
+			if err := indexBookInSearch(tx, &books[i]); err != nil {
+				return errors.Wrapf(err, "index book %d", books[i].ID)
+			}
+		}
+		if progress != nil {
+			progress(end, len(ids))
+		}
+	}
+	log.Printf("Indexed %d books", len(ids))
+	return nil
+}
+
+// reindexBookInSearch replaces a book's row in books_fts with one built from the book's current metadata and files.
+func reindexBookInSearch(tx *sql.Tx, id int64) error {
+	if _, err := tx.Exec("delete from books_fts where rowid=?", id); err != nil {
+		return errors.Wrap(err, "delete book from fts")
+	}
+	books, err := getBooksByID(tx, []int64{id})
+	if err != nil {
+		return errors.Wrap(err, "get book")
+	}
+	if len(books) == 0 {
+		return errors.Errorf("Can't find book %d to reindex", id)
+	}
+	if err := indexBookInSearch(tx, &books[0]); err != nil {
+		return errors.Wrap(err, "index book in search")
+	}
+	return nil
+}
+
+// indexBookInSearch adds a row to books_fts for a book, which must not already be indexed.
+// book must have been fully loaded from the library, including its files.
+func indexBookInSearch(tx *sql.Tx, book *Book) error {
+	extensions := []string{}
+	tags := []string{}
+	filenames := []string{}
+	sources := []string{}
+	for _, f := range book.Files {
+		tags = append(tags, f.Tags...)
+		extensions = append(extensions, f.Extension)
+		filenames = append(filenames, f.CurrentFilename)
+		if f.Source != "" {
+			sources = append(sources, f.Source)
+		}
+	}
+
+	_, err := tx.Exec(`insert into books_fts (rowid, author, series, title, extension, tags, filename, source, publisher, subject, description)
+	values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		book.ID, strings.Join(book.Authors, " & "), book.Series, book.Title,
+		strings.Join(extensions, " "), strings.Join(tags, " "), strings.Join(filenames, " "), strings.Join(sources, " "),
+		book.Publisher, strings.Join(book.Subjects, " & "), book.Description)
+	return err
+}
+
+func isFTSColumn(name string) bool {
+	for _, c := range ftsColumns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
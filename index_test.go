@@ -0,0 +1,23 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import "testing"
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		snippet, want string
+	}{
+		{"", ""},
+		{"no matches", "no matches"},
+		{"The " + HighlightStart + "Shining" + HighlightEnd, "The <mark>Shining</mark>"},
+		{HighlightStart + "Tom" + HighlightEnd + " & <Jerry>", "<mark>Tom</mark> &amp; &lt;Jerry&gt;"},
+	}
+	for _, test := range tests {
+		if got := HighlightHTML(test.snippet); got != test.want {
+			t.Errorf("HighlightHTML(%q) = %q, want %q", test.snippet, got, test.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package books
 import (
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -109,15 +110,50 @@ type Library struct {
 	*sql.DB
 	filename  string
 	booksRoot string
+	// fields holds the custom fields set with SetCustomFields.
+	fields []CustomField
 }
 
 // OpenLibrary opens a library stored in a file.
+// If the library's schema is out of date, it is backed up and any pending migrations are applied.
 func OpenLibrary(filename, booksRoot string) (*Library, error) {
+	lib, err := OpenLibraryWithoutMigrating(filename, booksRoot)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := lib.PendingMigrations()
+	if err != nil {
+		lib.Close()
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return lib, nil
+	}
+
+	backup, err := lib.Backup()
+	if err != nil {
+		lib.Close()
+		return nil, err
+	}
+	log.Printf("Backed up library to %s before migrating", backup)
+	if err := lib.Migrate(); err != nil {
+		lib.Close()
+		return nil, err
+	}
+	return lib, nil
+}
+
+// OpenLibraryWithoutMigrating opens a library stored in a file, leaving its schema as it is.
+// Most callers should use OpenLibrary instead.
+func OpenLibraryWithoutMigrating(filename, booksRoot string) (*Library, error) {
+	if err := checkFTS5(); err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("sqlite3async", filename)
 	if err != nil {
 		return nil, err
 	}
-	return &Library{db, filename, booksRoot}, nil
+	return &Library{DB: db, filename: filename, booksRoot: booksRoot}, nil
 }
 
 // CreateLibrary initializes a new library in the specified file.
@@ -125,6 +161,9 @@ func OpenLibrary(filename, booksRoot string) (*Library, error) {
 // Warning: This function sets up a new library for the first time. To get a Library based on an existing library file,
 // call OpenLibrary.
 func CreateLibrary(filename string) error {
+	if err := checkFTS5(); err != nil {
+		return err
+	}
 	log.Printf("Creating library in %s\n", filename)
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
@@ -136,183 +175,261 @@ func CreateLibrary(filename string) error {
 	if err != nil {
 		return errors.Wrap(err, "Create library")
 	}
+	if err := migrate(db); err != nil {
+		return errors.Wrap(err, "Create library")
+	}
 
 	log.Printf("Library created in %s\n", filename)
 	return nil
 }
 
+// checkFTS5 returns an error if SQLite was built without FTS5, which the search index needs.
+// go-sqlite3 only includes it when built with the sqlite_fts5 tag, as mage build does.
+func checkFTS5() error {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return errors.Wrap(err, "check for FTS5")
+	}
+	defer db.Close()
+
+	var enabled bool
+	if err := db.QueryRow("select sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return errors.Wrap(err, "check for FTS5")
+	}
+	if !enabled {
+		return errors.New("SQLite was built without FTS5, which is needed for searching; build books with mage, or with go build -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// DuplicateFileError is returned by ImportBook when a file with the same hash is already in the library.
+type DuplicateFileError struct {
+	FileID int64
+}
+
+func (e DuplicateFileError) Error() string {
+	return fmt.Sprintf("A duplicate book already exists with id %d", e.FileID)
+}
+
 // ImportBook adds a book to a library.
 // The file referred to by book.OriginalFilename will either be copied or moved to the location referred to by book.CurrentFilename, relative to the configured books root.
-// The book will not be imported if another book already in the library has the same hash.
-func (lib *Library) ImportBook(book Book, move bool) error {
+// The book will not be imported if another book already in the library has the same hash; in that case, the error is a DuplicateFileError.
+// It returns the ID of the book the file was added to, which is an existing book if one has any of the same identifiers,
+// such as an ISBN, or failing that, the same title and authors.
+// If the book doesn't have a cover yet, one is extracted from the file's EPUB manifest, an image next to it,
+// or the first page of a PDF.
+func (lib *Library) ImportBook(book Book, move bool) (int64, error) {
 	if len(book.Files) != 1 {
-		return errors.New("Book to import must contain only one file")
+		return 0, errors.New("Book to import must contain only one file")
 	}
 	bf := book.Files[0]
 	tx, err := lib.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	rows, err := tx.Query("select id from files where hash=?", bf.Hash)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return 0, err
 	}
 	if rows.Next() {
 		// This book's hash is already in the library.
 		var id int64
 		rows.Scan(&id)
 		tx.Rollback()
-		return errors.Errorf("A duplicate book already exists with id %d", id)
+		return 0, DuplicateFileError{id}
 	}
 
 	rows.Close()
 	if rows.Err() != nil {
 		tx.Rollback()
-		return errors.Wrapf(err, "Searching for duplicate book by hash %s", bf.Hash)
+		return 0, errors.Wrapf(err, "Searching for duplicate book by hash %s", bf.Hash)
 	}
 
-	existingBookID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors)
+	existingBookID, found, err := getBookIDByIdentifiers(tx, book.Identifiers)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "find existing book")
+		return 0, errors.Wrap(err, "find existing book by identifier")
 	}
 	if !found {
-		res, err := tx.Exec("insert into books (series, title) values(?, ?)", book.Series, book.Title)
+		existingBookID, found, err = getBookIDByTitleAndAuthors(tx, book.Title, book.Authors)
 		if err != nil {
 			tx.Rollback()
-			return errors.Wrap(err, "Insert new book")
+			return 0, errors.Wrap(err, "find existing book")
 		}
-		book.ID, err = res.LastInsertId()
-		if err != nil {
-			tx.Rollback()
-			return errors.Wrap(err, "sett new book ID")
-		}
-		for _, author := range book.Authors {
-			if err := insertAuthor(tx, author, &book); err != nil {
+	}
+	// The cover is read before the file is moved, but only stored once the import is committed,
+	// so a failed import doesn't leave a cover behind that no book uses.
+	var coverData []byte
+	if book.Cover == "" {
+		var hasCover bool
+		if found {
+			if err := tx.QueryRow("select cover != '' from books where id=?", existingBookID).Scan(&hasCover); err != nil {
 				tx.Rollback()
-				return errors.Wrapf(err, "inserting author %s", author)
+				return 0, errors.Wrap(err, "check for existing cover")
 			}
 		}
-
+		if !hasCover {
+			coverData = findCover(bf.OriginalFilename)
+		}
+	}
+	if !found {
+		if err := insertBook(tx, &book); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
 	} else {
 		book.ID = existingBookID
+		if err := fillDescriptiveMetadata(tx, book.ID, &book); err != nil {
+			tx.Rollback()
+			return 0, errors.Wrap(err, "fill in book metadata")
+		}
+	}
+	for _, id := range book.Identifiers {
+		if err := insertIdentifier(tx, book.ID, id); err != nil {
+			tx.Rollback()
+			return 0, errors.Wrapf(err, "inserting identifier %s", id)
+		}
 	}
 
-	res, err := tx.Exec(`insert into files (book_id, extension, original_filename, filename, file_size, file_mtime, hash, source)
-	values (?, ?, ?, ?, ?, ?, ?, ?)`,
-		book.ID, bf.Extension, bf.OriginalFilename, bf.CurrentFilename, bf.FileSize, bf.FileMtime, bf.Hash, bf.Source)
+	res, err := tx.Exec(`insert into files (book_id, extension, original_filename, filename, file_size, file_mtime, hash, source, template_override)
+	values (?, ?, ?, ?, ?, ?, ?, ?, nullif(?, ''))`,
+		book.ID, bf.Extension, bf.OriginalFilename, bf.CurrentFilename, bf.FileSize, bf.FileMtime, bf.Hash, bf.Source, bf.TemplateOverride)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "Inserting book file into the db")
+		return 0, errors.Wrap(err, "Inserting book file into the db")
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "Fetching new book ID")
+		return 0, errors.Wrap(err, "Fetching new book ID")
 	}
 	bf.ID = id
 
 	for _, tag := range bf.Tags {
 		if err := insertTag(tx, tag, &bf); err != nil {
 			tx.Rollback()
-			return errors.Wrapf(err, "inserting tag %s", tag)
+			return 0, errors.Wrapf(err, "inserting tag %s", tag)
 		}
 	}
 
-	err = indexBookInSearch(tx, &book, !found)
+	err = reindexBookInSearch(tx, book.ID)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "index book in search")
+		return 0, errors.Wrap(err, "index book in search")
 	}
 
 	err = lib.moveOrCopyFile(book, move)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "Moving or copying book")
+		return 0, errors.Wrap(err, "Moving or copying book")
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return errors.Wrap(err, "import book")
+		return 0, errors.Wrap(err, "import book")
+	}
+	if cover := lib.storeFoundCover(bf.OriginalFilename, coverData); cover != "" {
+		if _, err := lib.Exec("update books set updated_on=datetime(), cover=? where id=? and cover=''", cover, book.ID); err != nil {
+			log.Printf("Error setting cover of book %d: %s", book.ID, err)
+		}
 	}
 	log.Printf("Imported book: %s: %s, ID = %d", strings.Join(book.Authors, " & "), book.Title, book.ID)
 
-	return nil
+	return book.ID, nil
 }
 
-func indexBookInSearch(tx *sql.Tx, book *Book, createNew bool) error {
-	if !createNew && len(book.Files) != 1 {
-		return errors.New("Book to index must contain only one file")
+// insertBook inserts a new book and its authors into the database, and sets book.ID.
+// The book's files are not inserted.
+func insertBook(tx *sql.Tx, book *Book) error {
+	res, err := tx.Exec("insert into books (series, series_index, title, publisher, published, language, description, cover) values(?, ?, ?, ?, ?, ?, ?, ?)",
+		book.Series, book.SeriesIndex, book.Title, book.Publisher, book.Published, book.Language, book.Description, book.Cover)
+	if err != nil {
+		return errors.Wrap(err, "Insert new book")
 	}
-	bf := book.Files[0]
-	joinedTags := strings.Join(bf.Tags, " ")
-	if createNew {
-		// Index book for searching.
-		extensions := []string{}
-		tags := []string{}
-		sources := []string{}
-		for _, f := range book.Files {
-			tags = append(tags, f.Tags...)
-			extensions = append(extensions, f.Extension)
-			sources = append(sources, f.Source)
-		}
-
-		_, err := tx.Exec(`insert into books_fts (docid, author, series, title, extension, tags,  source)
-	values (?, ?, ?, ?, ?, ?, ?)`,
-			book.ID, strings.Join(book.Authors, " & "), book.Series, book.Title, strings.Join(extensions, " "), strings.Join(tags, " "), strings.Join(sources, " "))
-		if err != nil {
+	book.ID, err = res.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "set new book ID")
+	}
+	for _, author := range book.Authors {
+		if err := insertAuthor(tx, author, book); err != nil {
+			return errors.Wrapf(err, "inserting author %s", author)
+		}
+	}
+	if err := insertSubjects(tx, book.ID, book.Subjects); err != nil {
+		return errors.Wrap(err, "inserting subjects")
+	}
+	return nil
+}
+
+// descriptiveColumns are the columns of books holding descriptive metadata and the cover, which are only filled in where they're missing
+// when a book gets another file or is merged with another book.
+var descriptiveColumns = []string{"publisher", "published", "language", "description", "cover"}
+
+// fillDescriptiveMetadata sets the publisher, publication date, language, description, cover and subjects of an existing book
+// from book, keeping any the existing book already has.
+func fillDescriptiveMetadata(tx *sql.Tx, id int64, book *Book) error {
+	values := []string{book.Publisher, book.Published, book.Language, book.Description, book.Cover}
+	for i, column := range descriptiveColumns {
+		if values[i] == "" {
+			continue
+		}
+		if _, err := tx.Exec("update books set "+column+"=? where id=? and "+column+"=''", values[i], id); err != nil {
 			return err
 		}
-		return nil
 	}
-	rows, err := tx.Query("select docid, tags, extension, source from books_fts where docid=?", book.ID)
-	if err != nil {
+	var hasSubjects bool
+	if err := tx.QueryRow("select exists (select 1 from subjects where book_id=?)", id).Scan(&hasSubjects); err != nil {
 		return err
 	}
-	if !rows.Next() {
-		rows.Close()
-		if rows.Err() != nil {
+	if hasSubjects {
+		return nil
+	}
+	return insertSubjects(tx, id, book.Subjects)
+}
+
+// insertSubjects adds subjects to a book, skipping any it already has.
+func insertSubjects(tx *sql.Tx, id int64, subjects []string) error {
+	for _, subject := range subjects {
+		if _, err := tx.Exec("insert or ignore into subjects (book_id, name) values (?, ?)", id, subject); err != nil {
 			return err
 		}
-		return errors.Errorf("Existing book %d not found in FTS", book.ID)
 	}
-	var id int64
-	var tags, extension, source string
-	err = rows.Scan(&id, &tags, &extension, &source)
+	return nil
+}
+
+// getSubjectsByBookIDs gets the subjects of each book ID, in the order they were added.
+func getSubjectsByBookIDs(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
+	m := make(map[int64][]string)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select book_id, name from subjects where book_id in (" + joinInt64s(ids, ",") + ") order by id")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	rows.Close()
+	defer rows.Close()
 
-	_, err = tx.Exec("update books_fts set tags=?, extension=?, source=? where docid=?", tags+" "+joinedTags, extension+" "+bf.Extension, source+" "+bf.Source, id)
-	if err != nil {
-		return err
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		m[id] = append(m[id], name)
 	}
-	return nil
+	return m, rows.Err()
 }
 
-// insertAuthor inserts an author into the database.
+// insertAuthor links an author to a book, adding the author if it doesn't exist.
+// Aliases and sort names are resolved as described in resolveAuthor, so a book is always linked to the canonical author.
 func insertAuthor(tx *sql.Tx, author string, book *Book) error {
-	var authorID int64
-	row := tx.QueryRow("select id from authors where name=?", author)
-	err := row.Scan(&authorID)
-	if err == sql.ErrNoRows {
-		// Insert the author
-		res, err := tx.Exec("insert into authors (name) values(?)", author)
-		if err != nil {
-			return err
-		}
-		authorID, err = res.LastInsertId()
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
+	authorID, err := getOrInsertAuthor(tx, author)
+	if err != nil {
 		return err
 	}
-	// Author inserted, insert the link
 	// For two authors in the same book with the same name, only insert one.
 	if _, err := tx.Exec("insert or ignore into books_authors (book_id, author_id) values(?, ?)", book.ID, authorID); err != nil {
 		return err
@@ -346,6 +463,57 @@ func insertTag(tx *sql.Tx, tag string, bf *BookFile) error {
 	return nil
 }
 
+// SetFileTags replaces the tags of the file with the given ID.
+// Tags which are no longer used by any file are deleted.
+func (lib *Library) SetFileTags(fileID int64, tags []string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "get transaction")
+	}
+	var bookID int64
+	if err := tx.QueryRow("select book_id from files where id=?", fileID).Scan(&bookID); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "get file %d", fileID)
+	}
+	if _, err := tx.Exec("delete from files_tags where file_id=?", fileID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete tags")
+	}
+	bf := BookFile{ID: fileID}
+	for _, tag := range tags {
+		if err := insertTag(tx, tag, &bf); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "inserting tag %s", tag)
+		}
+	}
+	if err := deleteOrphans(tx); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete orphans")
+	}
+	if err := reindexBookInSearch(tx, bookID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "index book in search")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "set tags")
+	}
+	return nil
+}
+
+// SetOriginalFilename changes the name a file was imported from, for files imported from a temporary copy, such as uploads.
+func (lib *Library) SetOriginalFilename(fileID int64, name string) error {
+	res, err := lib.Exec("update files set updated_on=datetime(), original_filename=? where id=?", name, fileID)
+	if err != nil {
+		return errors.Wrap(err, "set original filename")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "set original filename")
+	} else if n == 0 {
+		return errors.Errorf("file %d not found", fileID)
+	}
+	return nil
+}
+
 // moveOrCopyFile moves or copies a file from book.OriginalFilename to book.CurrentFilename, relative to the configured books root.
 // All necessary directories to make the destination valid will be created.
 func (lib *Library) moveOrCopyFile(book Book, move bool) error {
@@ -372,53 +540,157 @@ func (lib *Library) moveOrCopyFile(book Book, move bool) error {
 	return nil
 }
 
-// Search searches the library for books.
+// Search searches the library for books, using the query language described in ParseQuery.
 // By default, all fields are searched, but
-// field:terms+to+search will limit to that field only.
-// Fields: author, title, series, extension, tags, filename, source.
-// Example: author:Stephen+King title:Shining
-func (lib *Library) Search(terms string) ([]Book, error) {
-	books, _, err := lib.SearchPaged(terms, 0, 0, 0)
-	return books, err
+// field:value will limit to that field only.
+// Fields: author, title, series, extension, tags, filename, source, publisher, subject, description,
+// the filters ext, tag, added, size, status, rating, started, finished, isbn, asin, identifier, language, published and shelf,
+// and the custom fields set with SetCustomFields.
+// Example: author:"Stephen King" title:Shining ext:epub sort:-added
+//
+// If nothing matches, similar queries are returned as suggestions, as described in SearchPaged.
+func (lib *Library) Search(terms string) ([]Book, []string, error) {
+	books, _, suggestions, err := lib.SearchPaged(terms, 0, 0, 0)
+	return books, suggestions, err
 }
 
 // SearchPaged implements book searching, both paged and non paged.
+// Results are ordered by relevance unless the query sorts them, and each book's Snippet is set to the best matching text.
 // Set limit to 0 to return all results.
 // moreResults will be set to the number of additional results not returned, with a maximum of moreResultsLimit.
-func (lib *Library) SearchPaged(terms string, offset, limit, moreResultsLimit int) (books []Book, moreResults int, err error) {
+// If the query is invalid, the returned error is a QueryError.
+//
+// If nothing matches, the query is compared with the authors, titles and series in the library to correct misspellings,
+// and the similar queries which have results are returned as suggestions, closest first.
+// In that case, the results returned are those of the first suggestion.
+func (lib *Library) SearchPaged(terms string, offset, limit, moreResultsLimit int) (books []Book, moreResults int, suggestions []string, err error) {
 	books = []Book{}
+	q, err := ParseQuery(terms)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	ids, snippets, err := lib.searchIDs(q, offset, limit, moreResultsLimit)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if len(ids) == 0 && offset > 0 {
+		// The offset may just be past the last result.
+		if first, _, err := lib.searchIDs(q, 0, 1, 0); err != nil || len(first) > 0 {
+			return books, 0, nil, err
+		}
+	}
+	if len(ids) == 0 {
+		candidates, err := lib.suggest(q)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		for _, c := range candidates {
+			cq, err := ParseQuery(c)
+			if err != nil {
+				return nil, 0, nil, errors.Wrapf(err, "parse suggestion %s", c)
+			}
+			cIDs, cSnippets, err := lib.searchIDs(cq, offset, limit, moreResultsLimit)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if len(cIDs) == 0 {
+				continue
+			}
+			if len(suggestions) == 0 {
+				ids, snippets = cIDs, cSnippets
+			}
+			suggestions = append(suggestions, c)
+		}
+	}
+
+	books, moreResults, err = lib.searchResults(ids, snippets, limit)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return books, moreResults, suggestions, nil
+}
+
+// searchResults gets the books with the IDs returned by searchIDs, in the same order and with their snippets,
+// and returns the first limit of them and the number left over.
+func (lib *Library) searchResults(ids []int64, snippets map[int64]string, limit int) ([]Book, int, error) {
+	var moreResults int
+	if limit > 0 && len(ids) > limit {
+		moreResults = len(ids) - limit
+		ids = ids[:limit]
+	}
+	books, err := lib.GetBooksByID(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	books = sortBooksByID(books, ids)
+	for i := range books {
+		books[i].Snippet = snippets[books[i].ID]
+	}
+	return books, moreResults, nil
+}
+
+// searchIDs returns the IDs of books matching a query in order, and a snippet for each book.
+// If limit is not 0, up to limit+moreResultsLimit IDs are returned.
+func (lib *Library) searchIDs(q *Query, offset, limit, moreResultsLimit int) ([]int64, map[int64]string, error) {
+	cq, err := compileQuery(q, lib.fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var query string
-	args := []interface{}{terms}
-	if limit == 0 {
-		query = "select docid from books_fts where books_fts match ?"
+	var args []interface{}
+	if cq.rank != "" {
+		query = `select b.id, coalesce(r.snippet, '') from books b
+		left join (select rowid, snippet(books_fts, -1, ?, ?, '…', 12) as snippet, bm25(books_fts, ` + ftsWeights + `) as rank
+		from books_fts where books_fts match ?) r on r.rowid = b.id`
+		args = append(args, HighlightStart, HighlightEnd, cq.rank)
 	} else {
-		query = "select docid from books_fts where books_fts match ? LIMIT ? OFFSET ?"
+		query = "select b.id, '' from books b"
+	}
+	query += " where " + cq.where + " order by " + cq.orderBy
+	args = append(args, cq.args...)
+	if limit != 0 {
+		query += " LIMIT ? OFFSET ?"
 		args = append(args, limit+moreResultsLimit, offset)
 	}
 
 	rows, err := lib.Query(query, args...)
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "Querying db for search terms")
+		return nil, nil, errors.Wrap(err, "Querying db for search terms")
 	}
+	defer rows.Close()
 
 	var ids []int64
 	var id int64
+	var snippet string
+	snippets := make(map[int64]string)
 	for rows.Next() {
-		rows.Scan(&id)
+		if err := rows.Scan(&id, &snippet); err != nil {
+			return nil, nil, errors.Wrap(err, "Retrieving search results from db")
+		}
 		ids = append(ids, id)
+		snippets[id] = snippet
 	}
-	err = rows.Err()
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "Retrieving search results from db")
+	if err := rows.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, "Retrieving search results from db")
 	}
+	return ids, snippets, nil
+}
 
-	if limit > 0 && len(ids) > limit {
-		moreResults = len(ids) - limit
-		ids = ids[:limit]
+// sortBooksByID returns books in the same order as their IDs appear in ids.
+func sortBooksByID(books []Book, ids []int64) []Book {
+	m := make(map[int64]Book, len(books))
+	for _, book := range books {
+		m[book.ID] = book
 	}
-	books, err = lib.GetBooksByID(ids)
-
-	return
+	sorted := make([]Book, 0, len(books))
+	for _, id := range ids {
+		if book, ok := m[id]; ok {
+			sorted = append(sorted, book)
+		}
+	}
+	return sorted
 }
 
 // GetBooksByID retrieves books from the library by their id.
@@ -450,7 +722,7 @@ func getBooksByID(tx *sql.Tx, ids []int64) ([]Book, error) {
 
 	results := []Book{}
 
-	query := "select id, series, title from books where id in (" + joinInt64s(ids, ",") + ")"
+	query := "select id, series, series_index, title, publisher, published, language, description, cover from books where id in (" + joinInt64s(ids, ",") + ")"
 	rows, err := tx.Query(query)
 	if err != nil {
 		return results, errors.Wrap(err, "fetching books from database by ID")
@@ -458,7 +730,7 @@ func getBooksByID(tx *sql.Tx, ids []int64) ([]Book, error) {
 
 	for rows.Next() {
 		book := Book{}
-		if err := rows.Scan(&book.ID, &book.Series, &book.Title); err != nil {
+		if err := rows.Scan(&book.ID, &book.Series, &book.SeriesIndex, &book.Title, &book.Publisher, &book.Published, &book.Language, &book.Description, &book.Cover); err != nil {
 			return nil, errors.Wrap(err, "scanning rows")
 		}
 
@@ -480,10 +752,39 @@ func getBooksByID(tx *sql.Tx, ids []int64) ([]Book, error) {
 		return nil, errors.Wrap(err, "get files for books")
 	}
 
+	readingMap, err := getReadingByBookIDs(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get reading for books")
+	}
+
+	identifierMap, err := getIdentifiersByBookIDs(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get identifiers for books")
+	}
+
+	subjectMap, err := getSubjectsByBookIDs(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get subjects for books")
+	}
+
+	shelfMap, err := getShelvesByBookIDs(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get shelves for books")
+	}
+	fieldMap, err := getFieldsByBookIDs(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get custom fields for books")
+	}
+
 	// Get authors and files
 	for i, book := range results {
 		results[i].Authors = authorMap[book.ID]
 		results[i].Files = fileMap[book.ID]
+		results[i].Reading = readingMap[book.ID]
+		results[i].Identifiers = identifierMap[book.ID]
+		results[i].Subjects = subjectMap[book.ID]
+		results[i].Shelves = shelfMap[book.ID]
+		results[i].Fields = fieldMap[book.ID]
 	}
 	return results, nil
 }
@@ -611,7 +912,7 @@ func getFilesByID(tx *sql.Tx, ids []int64) ([]BookFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	query := "select id, extension, original_filename, filename, file_size, file_mtime, hash, source from files where id in (" + joinInt64s(ids, ",") + ")"
+	query := "select id, extension, original_filename, filename, file_size, file_mtime, hash, source, coalesce(template_override, '') from files where id in (" + joinInt64s(ids, ",") + ")"
 	rows, err := tx.Query(query)
 	if err != nil {
 		return nil, err
@@ -619,11 +920,14 @@ func getFilesByID(tx *sql.Tx, ids []int64) ([]BookFile, error) {
 	defer rows.Close()
 	for rows.Next() {
 		bf := BookFile{}
-		err := rows.Scan(&bf.ID, &bf.Extension, &bf.OriginalFilename, &bf.CurrentFilename, &bf.FileSize, &bf.FileMtime, &bf.Hash, &bf.Source)
+		err := rows.Scan(&bf.ID, &bf.Extension, &bf.OriginalFilename, &bf.CurrentFilename, &bf.FileSize, &bf.FileMtime, &bf.Hash, &bf.Source, &bf.TemplateOverride)
 		if err != nil {
 			return nil, err
 		}
 		bf.Tags = tagMap[bf.ID]
+		if bf.Tags == nil {
+			bf.Tags = []string{}
+		}
 		files = append(files, bf)
 	}
 	return files, nil
@@ -645,6 +949,7 @@ func (lib *Library) ConvertToEpub(file BookFile) error {
 }
 
 // UpdateBook updates the authors and title of an existing book in the database, specified by book.ID.
+// If updateSeries is true, the series and series index are updated as well.
 func (lib *Library) UpdateBook(book Book, updateSeries bool) error {
 	tx, err := lib.Begin()
 	if err != nil {
@@ -662,13 +967,14 @@ func (lib *Library) UpdateBook(book Book, updateSeries bool) error {
 	existingBook := existingBooks[0]
 	if existingBook.Title == book.Title &&
 		authorsEqual(existingBook.Authors, book.Authors) &&
-		(!updateSeries || existingBook.Series == book.Series) {
+		(!updateSeries || (existingBook.Series == book.Series && existingBook.SeriesIndex == book.SeriesIndex)) {
 		tx.Rollback()
 		log.Printf("Not updating book %d because nothing changed", book.ID)
 		return nil
 	}
 	existingBookID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "find existing book")
 	}
 	if found && book.ID != existingBookID {
@@ -677,9 +983,9 @@ func (lib *Library) UpdateBook(book Book, updateSeries bool) error {
 	}
 
 	if book.Title != existingBook.Title ||
-		(updateSeries && book.Series != existingBook.Series) {
+		(updateSeries && (book.Series != existingBook.Series || book.SeriesIndex != existingBook.SeriesIndex)) {
 		if updateSeries {
-			_, err = tx.Exec("update books set updated_on=datetime(), title=?, series=? where id=?", book.Title, book.Series, book.ID)
+			_, err = tx.Exec("update books set updated_on=datetime(), title=?, series=?, series_index=? where id=?", book.Title, book.Series, book.SeriesIndex, book.ID)
 		} else {
 			_, err = tx.Exec("update books set updated_on=datetime(), title=? where id=?", book.Title, book.ID)
 		}
@@ -701,8 +1007,11 @@ func (lib *Library) UpdateBook(book Book, updateSeries bool) error {
 			}
 		}
 	}
-	_, err = tx.Exec("update books_fts set title=?, author=? where docid=?", book.Title, strings.Join(book.Authors, " & "), book.ID)
-	if err != nil {
+	if err := deleteOrphans(tx); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete orphans")
+	}
+	if err := reindexBookInSearch(tx, book.ID); err != nil {
 		tx.Rollback()
 		return errors.Wrap(err, "update book")
 	}
@@ -793,10 +1102,25 @@ func (lib *Library) GetBookIDByTitleAndAuthors(title string, authors []string) (
 	return getBookIDByTitleAndAuthors(tx, title, authors)
 }
 
+// getBookIDByTitleAndAuthors finds a book by its title and authors.
+// If several books match, the one with the lowest ID is returned.
 func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int64, bool, error) {
-	rows, err := tx.Query("SELECT id FROM books WHERE title = ?", title)
+	ids, err := getBookIDsByTitleAndAuthors(tx, title, authors)
+	if err != nil || len(ids) == 0 {
+		return 0, false, err
+	}
+	return ids[0], true, nil
+}
+
+// getBookIDsByTitleAndAuthors returns the IDs of every book with the given title and authors, in order.
+func getBookIDsByTitleAndAuthors(tx *sql.Tx, title string, authors []string) ([]int64, error) {
+	authors, err := resolveAuthorNames(tx, authors)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query("SELECT id FROM books WHERE title = ? ORDER BY id", title)
 	if err != nil {
-		return 0, false, errors.Wrap(err, "get book by title")
+		return nil, errors.Wrap(err, "get book by title")
 	}
 
 	var id int64
@@ -804,7 +1128,7 @@ func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int
 	for rows.Next() {
 		err := rows.Scan(&id)
 		if err != nil {
-			return 0, false, errors.Wrap(err, "Get book ID from title")
+			return nil, errors.Wrap(err, "Get book ID from title")
 		}
 
 		ids = append(ids, id)
@@ -813,16 +1137,16 @@ func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int
 
 	authorMap, err := getAuthorsByBookIds(tx, ids)
 	if err != nil {
-		return 0, false, errors.Wrap(err, "get authors for books")
+		return nil, errors.Wrap(err, "get authors for books")
 	}
 
-	for bookID, authorNames := range authorMap {
-		if authorsEqual(authors, authorNames) {
-			return bookID, true, nil
+	matches := []int64{}
+	for _, bookID := range ids {
+		if authorsEqual(authors, authorMap[bookID]) {
+			matches = append(matches, bookID)
 		}
 	}
-
-	return 0, false, nil
+	return matches, nil
 }
 
 // MergeBooks merges all of the files from ids into the first one.
@@ -846,30 +1170,313 @@ func mergeBooks(tx *sql.Tx, ids []int64) error {
 	if err != nil {
 		return errors.Wrap(err, "merge books")
 	}
+	if err := mergeReading(tx, ids); err != nil {
+		return errors.Wrap(err, "merge reading")
+	}
+	if _, err = tx.Exec("update identifiers set book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0]); err != nil {
+		return errors.Wrap(err, "merge identifiers")
+	}
+	if err := mergeDescriptiveMetadata(tx, ids); err != nil {
+		return errors.Wrap(err, "merge descriptive metadata")
+	}
+	if err := mergeShelves(tx, ids); err != nil {
+		return errors.Wrap(err, "merge shelves")
+	}
+	if err := mergeFields(tx, ids); err != nil {
+		return errors.Wrap(err, "merge custom fields")
+	}
 	if _, err = tx.Exec("delete from books where id in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
 		return errors.Wrap(err, "delete book")
 	}
-	if _, err = tx.Exec("delete from books_fts where docid in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
+	if _, err = tx.Exec("delete from books_authors where book_id in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
+		return errors.Wrap(err, "delete authors")
+	}
+	if _, err = tx.Exec("delete from books_fts where rowid in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
 		return errors.Wrap(err, "delete from books_fts")
 	}
-	// Reindex the book in search
-	_, err = tx.Exec("delete from books_fts where docid=?", ids[0])
+	if err := reindexBookInSearch(tx, ids[0]); err != nil {
+		return errors.Wrap(err, "reindex original book")
+	}
+	if err := deleteOrphans(tx); err != nil {
+		return errors.Wrap(err, "delete orphans")
+	}
+	return nil
+}
+
+// mergeDescriptiveMetadata fills in the publisher, publication date, language, description and cover of the first book
+// from the other books with the lowest IDs that have them, and moves their subjects to it if it has none.
+func mergeDescriptiveMetadata(tx *sql.Tx, ids []int64) error {
+	others := joinInt64s(ids[1:], ",")
+	for _, column := range descriptiveColumns {
+		_, err := tx.Exec("update books set "+column+"=coalesce((select "+column+" from books where id in ("+others+") and "+column+"!='' order by id limit 1), '') where id=? and "+column+"=''", ids[0])
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`update subjects set book_id=? where book_id = (select book_id from subjects where book_id in (`+others+`) order by book_id limit 1)
+	and not exists (select 1 from subjects where book_id=?)`, ids[0], ids[0])
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("delete from subjects where book_id in (" + others + ")")
+	return err
+}
+
+// SplitFile moves a file out of its book and into another one, and returns the ID of the book it was moved into.
+// If newBook.ID is set, the file is moved into that book.
+// Otherwise, it is moved into the book with newBook's title and authors, which is created if it doesn't exist.
+// If the file's original book has no files left, that book is deleted.
+func (lib *Library) SplitFile(fileID int64, newBook Book) (int64, error) {
+	if newBook.ID == 0 && (newBook.Title == "" || len(newBook.Authors) == 0) {
+		return 0, errors.New("new book must have a title and authors")
+	}
+	tx, err := lib.Begin()
 	if err != nil {
-		return errors.Wrap(err, "delete original book from fts")
+		return 0, errors.Wrap(err, "get transaction")
+	}
+	var oldBookID int64
+	if err := tx.QueryRow("select book_id from files where id=?", fileID).Scan(&oldBookID); err == sql.ErrNoRows {
+		tx.Rollback()
+		return 0, errors.New("file not found")
+	} else if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "get book for file")
+	}
+
+	if newBook.ID != 0 {
+		existingBooks, err := getBooksByID(tx, []int64{newBook.ID})
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrap(err, "get new book")
+		}
+		if len(existingBooks) == 0 {
+			tx.Rollback()
+			return 0, errors.New("book not found")
+		}
+	} else {
+		existingBookID, found, err := getBookIDByTitleAndAuthors(tx, newBook.Title, newBook.Authors)
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrap(err, "find existing book")
+		}
+		if found {
+			newBook.ID = existingBookID
+		} else if err := insertBook(tx, &newBook); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if newBook.ID == oldBookID {
+		tx.Rollback()
+		return 0, errors.Errorf("file %d already belongs to book %d", fileID, oldBookID)
+	}
+
+	if _, err := tx.Exec("update files set updated_on=datetime(), book_id=? where id=?", newBook.ID, fileID); err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "move file")
+	}
+	var remaining int
+	if err := tx.QueryRow("select count(*) from files where book_id=?", oldBookID).Scan(&remaining); err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "count remaining files")
+	}
+	if remaining == 0 {
+		err = deleteBooks(tx, []int64{oldBookID})
+	} else {
+		err = reindexBookInSearch(tx, oldBookID)
 	}
-	books, err := getBooksByID(tx, []int64{ids[0]})
 	if err != nil {
-		return errors.Wrap(err, "get original book")
+		tx.Rollback()
+		return 0, errors.Wrap(err, "update original book")
+	}
+	if err := reindexBookInSearch(tx, newBook.ID); err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "reindex new book")
+	}
+	if err := deleteOrphans(tx); err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "delete orphans")
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "split file")
+	}
+	log.Printf("Moved file %d from book %d to book %d", fileID, oldBookID, newBook.ID)
+	return newBook.ID, nil
+}
+
+// DeleteBook removes a book and all of its files from the library.
+// If trashDir is empty, the book's files are deleted from the books root.
+// Otherwise, they are moved into trashDir, keeping their paths relative to the books root.
+// Files are removed after the book is deleted from the database, so a failed delete never leaves a book without its files;
+// a file which can't be removed stays in the books root, and an error is returned.
+func (lib *Library) DeleteBook(id int64, trashDir string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "get transaction")
+	}
+	books, err := getBooksByID(tx, []int64{id})
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get book")
 	}
 	if len(books) == 0 {
-		return errors.New("Can't find original book to reindex")
+		tx.Rollback()
+		return errors.New("book not found")
 	}
-	if err := indexBookInSearch(tx, &books[0], true); err != nil {
-		return errors.Wrap(err, "index book in search")
+	if err := deleteBooks(tx, []int64{id}); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete book")
+	}
+	if err := deleteOrphans(tx); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete orphans")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "delete book")
+	}
+	log.Printf("Deleted book %d: %s - %s", id, strings.Join(books[0].Authors, " & "), books[0].Title)
+	for _, bf := range books[0].Files {
+		if err := lib.removeBookFile(bf, trashDir); err != nil {
+			return errors.Wrapf(err, "book %d was deleted, but its file %s couldn't be removed", id, bf.CurrentFilename)
+		}
 	}
 	return nil
 }
 
+// DeleteFile removes a single file from the library.
+// If it was the last file belonging to its book, the book is deleted as well.
+// trashDir behaves as it does for DeleteBook.
+func (lib *Library) DeleteFile(id int64, trashDir string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "get transaction")
+	}
+	var bookID int64
+	if err := tx.QueryRow("select book_id from files where id=?", id).Scan(&bookID); err == sql.ErrNoRows {
+		tx.Rollback()
+		return errors.New("file not found")
+	} else if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get book for file")
+	}
+	files, err := getFilesByID(tx, []int64{id})
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get file")
+	}
+
+	if _, err := tx.Exec("delete from files_tags where file_id=?", id); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete tags")
+	}
+	if _, err := tx.Exec("delete from files where id=?", id); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete file")
+	}
+	var remaining int
+	if err := tx.QueryRow("select count(*) from files where book_id=?", bookID).Scan(&remaining); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "count remaining files")
+	}
+	if remaining == 0 {
+		err = deleteBooks(tx, []int64{bookID})
+	} else {
+		err = reindexBookInSearch(tx, bookID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "update book")
+	}
+	if err := deleteOrphans(tx); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete orphans")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "delete file")
+	}
+	log.Printf("Deleted file %d: %s", id, files[0].CurrentFilename)
+	if remaining == 0 {
+		log.Printf("Deleted book %d, which has no files left", bookID)
+	}
+	if err := lib.removeBookFile(files[0], trashDir); err != nil {
+		return errors.Wrapf(err, "file %d was deleted, but %s couldn't be removed", id, files[0].CurrentFilename)
+	}
+	return nil
+}
+
+// deleteBooks deletes books, their files, and everything linked to them from the database.
+// Foreign keys aren't enforced on every connection, so linked rows are deleted explicitly.
+func deleteBooks(tx *sql.Tx, ids []int64) error {
+	in := "(" + joinInt64s(ids, ",") + ")"
+	queries := []string{
+		"delete from files_tags where file_id in (select id from files where book_id in " + in + ")",
+		"delete from files where book_id in " + in,
+		"delete from books_authors where book_id in " + in,
+		"delete from books_fts where rowid in " + in,
+		"delete from reading where book_id in " + in,
+		"delete from identifiers where book_id in " + in,
+		"delete from subjects where book_id in " + in,
+		"delete from books_shelves where book_id in " + in,
+		"delete from custom_fields where book_id in " + in,
+		"delete from books where id in " + in,
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrphans deletes authors and tags which are no longer linked to any books or files.
+func deleteOrphans(tx *sql.Tx) error {
+	// Authors with aliases are kept, so the aliases still apply to books imported later.
+	if _, err := tx.Exec("delete from authors where id not in (select author_id from books_authors) and id not in (select author_id from author_aliases)"); err != nil {
+		return errors.Wrap(err, "delete orphaned authors")
+	}
+	if _, err := tx.Exec("delete from tags where id not in (select tag_id from files_tags)"); err != nil {
+		return errors.Wrap(err, "delete orphaned tags")
+	}
+	return nil
+}
+
+// removeBookFile deletes a book file from the books root, or moves it into trashDir if trashDir isn't empty.
+// A file which is already missing is not an error.
+// Any directories left empty under the books root are removed.
+func (lib *Library) removeBookFile(bf BookFile, trashDir string) error {
+	fn := path.Join(lib.booksRoot, bf.CurrentFilename)
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		log.Printf("File %d is already missing: %s", bf.ID, fn)
+		return nil
+	}
+	if trashDir == "" {
+		if err := os.Remove(fn); err != nil {
+			return err
+		}
+		log.Printf("Deleted %s", fn)
+	} else {
+		dst := GetUniqueName(path.Join(trashDir, bf.CurrentFilename))
+		if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := moveFile(fn, dst); err != nil {
+			return err
+		}
+	}
+	lib.removeEmptyDirs(path.Dir(fn))
+	return nil
+}
+
+// removeEmptyDirs removes dir and each of its parents until one isn't empty, stopping at the books root.
+func (lib *Library) removeEmptyDirs(dir string) {
+	root := path.Clean(lib.booksRoot)
+	for dir = path.Clean(dir); strings.HasPrefix(dir, root+"/"); dir = path.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
 // GetBookIDByFilename returns a book ID given a filename relative to books root.
 func (lib *Library) GetBookIDByFilename(fn string) (int64, error) {
 	tx, err := lib.Begin()
@@ -0,0 +1,227 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestLibrary creates an empty library in a temporary directory, which is removed when the test finishes,
+// and returns it along with the directory. The library's books root is the root subdirectory.
+// The test is skipped if SQLite was built without FTS5.
+func newTestLibrary(t *testing.T) (*Library, string) {
+	t.Helper()
+	if err := checkFTS5(); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "books-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fn := path.Join(dir, "books.db")
+	if err := CreateLibrary(fn); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := OpenLibrary(fn, path.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lib.Close() })
+	return lib, dir
+}
+
+// importTestBook imports a file holding contents as a book by author, and returns the ID of the book.
+// The file is moved to author/title.ext in the books root.
+func importTestBook(t *testing.T, lib *Library, dir, author, title, ext, contents string, tags ...string) int64 {
+	t.Helper()
+	fn := path.Join(dir, title+"."+ext)
+	if err := ioutil.WriteFile(fn, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bf := BookFile{
+		OriginalFilename: fn,
+		CurrentFilename:  author + "/" + title + "." + ext,
+		Extension:        ext,
+		FileSize:         int64(len(contents)),
+		FileMtime:        time.Now(),
+		Tags:             tags,
+	}
+	if err := bf.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := lib.ImportBook(Book{Title: title, Authors: []string{author}, Files: []BookFile{bf}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// countRows returns the result of a query which counts rows.
+func countRows(t *testing.T, lib *Library, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := lib.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+func TestDeleteBook(t *testing.T) {
+	lib, dir := newTestLibrary(t)
+	id := importTestBook(t, lib, dir, "Terry Goodkind", "Wizards First Rule", "txt", "wizards", "retail")
+	other := importTestBook(t, lib, dir, "Stephen King", "The Shining", "txt", "shining")
+	fn := path.Join(dir, "root", "Terry Goodkind", "Wizards First Rule.txt")
+
+	// A deferred foreign key makes the transaction fail when it's committed, after the book's rows have been deleted.
+	// Its file must be left alone.
+	for _, q := range []string{
+		"create table keep (book_id integer references books(id) deferrable initially deferred)",
+		"insert into keep values (" + strconv.FormatInt(id, 10) + ")",
+	} {
+		if _, err := lib.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lib.DeleteBook(id, ""); err == nil {
+		t.Fatal("DeleteBook succeeded when the book couldn't be deleted")
+	}
+	if _, err := os.Stat(fn); err != nil {
+		t.Fatalf("file removed by a failed delete: %v", err)
+	}
+	if n := countRows(t, lib, "select count(*) from files where book_id=?", id); n != 1 {
+		t.Fatalf("book has %d files after a failed delete, want 1", n)
+	}
+	if _, err := lib.Exec("delete from keep"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.DeleteBook(id, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Errorf("file wasn't removed: %v", err)
+	}
+	if _, err := os.Stat(path.Dir(fn)); !os.IsNotExist(err) {
+		t.Errorf("empty directory wasn't removed: %v", err)
+	}
+	for _, q := range []string{
+		"select count(*) from books where id=?",
+		"select count(*) from files where book_id=?",
+		"select count(*) from books_authors where book_id=?",
+		"select count(*) from books_fts where rowid=?",
+	} {
+		if n := countRows(t, lib, q, id); n != 0 {
+			t.Errorf("%s: %d rows left", q, n)
+		}
+	}
+	if n := countRows(t, lib, "select count(*) from authors where name='Terry Goodkind'"); n != 0 {
+		t.Error("unused author wasn't deleted")
+	}
+	if n := countRows(t, lib, "select count(*) from tags where name='retail'"); n != 0 {
+		t.Error("unused tag wasn't deleted")
+	}
+	if n := countRows(t, lib, "select count(*) from books where id=?", other); n != 1 {
+		t.Error("another book was deleted")
+	}
+	if err := lib.DeleteBook(id, ""); err == nil {
+		t.Error("deleted a book which doesn't exist")
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	lib, dir := newTestLibrary(t)
+	id := importTestBook(t, lib, dir, "Terry Goodkind", "Wizards First Rule", "txt", "wizards")
+	importTestBook(t, lib, dir, "Terry Goodkind", "Wizards First Rule", "mobi", "wizards mobi")
+	books, err := lib.GetBooksByID([]int64{id})
+	if err != nil || len(books) != 1 || len(books[0].Files) != 2 {
+		t.Fatalf("GetBooksByID(%d) = %v, %v, want one book with two files", id, books, err)
+	}
+	files := books[0].Files
+	trash := path.Join(dir, "trash")
+
+	if err := lib.DeleteFile(files[0].ID, trash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(trash, files[0].CurrentFilename)); err != nil {
+		t.Errorf("file wasn't moved to the trash: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, "root", files[0].CurrentFilename)); !os.IsNotExist(err) {
+		t.Errorf("file is still in the books root: %v", err)
+	}
+	if books, _, err := lib.Search("ext:" + files[0].Extension); err != nil || len(books) != 0 {
+		t.Errorf("the deleted file's extension is still searchable: %v, %v", books, err)
+	}
+	if n := countRows(t, lib, "select count(*) from books where id=?", id); n != 1 {
+		t.Fatal("the book was deleted while it still had a file")
+	}
+
+	if err := lib.DeleteFile(files[1].ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, lib, "select count(*) from books where id=?", id); n != 0 {
+		t.Error("the book wasn't deleted with its last file")
+	}
+	if err := lib.DeleteFile(files[1].ID, ""); err == nil {
+		t.Error("deleted a file which doesn't exist")
+	}
+}
+
+func TestSplitFile(t *testing.T) {
+	lib, dir := newTestLibrary(t)
+	id := importTestBook(t, lib, dir, "Terry Goodkind", "Wizards First Rule", "txt", "wizards", "retail")
+	importTestBook(t, lib, dir, "Terry Goodkind", "Wizards First Rule", "mobi", "stone", "ocr")
+	books, err := lib.GetBooksByID([]int64{id})
+	if err != nil || len(books) != 1 || len(books[0].Files) != 2 {
+		t.Fatalf("GetBooksByID(%d) = %v, %v, want one book with two files", id, books, err)
+	}
+	fileID := books[0].Files[1].ID
+
+	newID, err := lib.SplitFile(fileID, Book{Title: "Stone of Tears", Authors: []string{"Terry Goodkind"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID == id {
+		t.Fatal("the file wasn't moved to a new book")
+	}
+	books, err = lib.GetBooksByID([]int64{id, newID})
+	if err != nil || len(books) != 2 {
+		t.Fatalf("GetBooksByID = %v, %v", books, err)
+	}
+	for _, b := range books {
+		if len(b.Files) != 1 {
+			t.Fatalf("book %d has %d files, want 1", b.ID, len(b.Files))
+		}
+		want := "retail"
+		if b.ID == newID {
+			want = "ocr"
+		}
+		if tags := b.Files[0].Tags; len(tags) != 1 || tags[0] != want {
+			t.Errorf("book %d has tags %v, want %s", b.ID, tags, want)
+		}
+	}
+	// The search index of both books must follow the file and its tags.
+	for q, want := range map[string]int64{"tag:ocr": newID, "tags:ocr": newID, "ext:mobi": newID, "tags:retail": id, "stone": newID} {
+		if books, _, err := lib.Search(q); err != nil || len(books) != 1 || books[0].ID != want {
+			t.Errorf("Search(%q) = %v, %v, want book %d", q, books, err, want)
+		}
+	}
+
+	// Moving the file back leaves the new book without files, so it's deleted.
+	if _, err := lib.SplitFile(fileID, Book{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, lib, "select count(*) from books where id=?", newID); n != 0 {
+		t.Error("the book left without files wasn't deleted")
+	}
+	if _, err := lib.SplitFile(fileID, Book{ID: id}); err == nil {
+		t.Error("split a file into the book it already belongs to")
+	}
+}
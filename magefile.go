@@ -14,6 +14,9 @@ const (
 	packageName = "github.com/tspivey/books/cmd/books"
 	ldflags     = "-X " + packageName + "/commands.Version=$VERSION"
 	outDir      = "bin"
+
+	// go-sqlite3 only includes FTS5, which the search index uses, with this tag.
+	tags = "sqlite_fts5"
 )
 
 var Default = Build
@@ -35,12 +38,12 @@ func init() {
 // Build builds Books.
 func Build() error {
 	mg.Deps(mkBin)
-	return sh.RunWith(getVars(), goexe, "build", "-ldflags", ldflags, "-o", path.Join(outDir, "$BIN_NAME"), packageName)
+	return sh.RunWith(getVars(), goexe, "build", "-tags", tags, "-ldflags", ldflags, "-o", path.Join(outDir, "$BIN_NAME"), packageName)
 }
 
 // Install installs Books.
 func Install() error {
-	return sh.RunWith(getVars(), goexe, "install", "-ldflags", ldflags, packageName)
+	return sh.RunWith(getVars(), goexe, "install", "-tags", tags, "-ldflags", ldflags, packageName)
 }
 
 // Clean removes all files and directories created by mage targets.
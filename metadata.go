@@ -1,12 +1,17 @@
 package books
 
 import (
+	"html"
 	"log"
+	"math"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
 )
 
 // A MetadataParser is used to parse the metadata for a book from a list of BookFiles.
@@ -22,6 +27,8 @@ type MetadataParser interface {
 // Each BookFile will still have their tags and extension set individually.
 // Each file’s extension will be trimmed before regular expressions are tested.
 // If a file doesn’t match the used regular expression, it will be included with its extension and no tags.
+// The named groups author, title, series and series_index set the matching fields of the book,
+// and a valid ISBN in the isbn group is added to its identifiers.
 // Regexps and RegexpNames must match.
 type RegexpMetadataParser struct {
 	Regexps     []*regexp.Regexp
@@ -46,12 +53,42 @@ func (p *RegexpMetadataParser) Parse(files []string) (book Book, parsed bool) {
 			}
 			book.Title = mapping["title"]
 			book.Series = mapping["series"]
+			book.SeriesIndex = parseSeriesIndex(mapping["series_index"])
+			if mapping["isbn"] != "" {
+				if isbn, err := NormalizeISBN(mapping["isbn"]); err == nil {
+					book.Identifiers = append(book.Identifiers, Identifier{"isbn", isbn})
+				} else {
+					log.Printf("Ignoring %s", err)
+				}
+			}
 			return book, true
 		}
 	}
 	return
 }
 
+// ParseSeriesIndex parses a series index such as 2 or 2.5. An empty string or "none" returns 0, meaning no index.
+func ParseSeriesIndex(s string) (float64, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if s == "" || strings.ToLower(s) == "none" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, errors.Errorf("invalid series index %s; use a number such as 2 or 2.5", s)
+	}
+	return n, nil
+}
+
+// parseSeriesIndex parses a series index found in metadata, returning 0 if it's missing or invalid.
+func parseSeriesIndex(s string) float64 {
+	n, err := ParseSeriesIndex(s)
+	if err != nil {
+		log.Printf("Ignoring %s", err)
+	}
+	return n
+}
+
 // re2map returns a map of named groups to their matches.
 // Example:
 //     regexp: ^(?P<first>\w+) (?P<second>\w+)$
@@ -75,6 +112,10 @@ func re2map(s string, r *regexp.Regexp) map[string]string {
 	return rmap
 }
 
+// An EpubMetadataParser parses book metadata from the OPF metadata of the first EPUB file which has a title and authors.
+// The series and series index are read from the calibre:series and calibre:series_index meta tags, if present.
+// Identifiers are read from dc:identifier, using its scheme or a prefix such as urn:isbn: for the type.
+// The publisher, publication date, language, description and subjects are read from their Dublin Core elements.
 type EpubMetadataParser struct{}
 
 func (*EpubMetadataParser) Parse(files []string) (book Book, parsed bool) {
@@ -105,6 +146,38 @@ func (*EpubMetadataParser) Parse(files []string) (book Book, parsed bool) {
 			f.Close()
 			continue
 		}
+		for _, meta := range m.Meta {
+			switch meta.Name {
+			case "calibre:series":
+				book.Series = meta.Content
+			case "calibre:series_index":
+				book.SeriesIndex = parseSeriesIndex(meta.Content)
+			}
+		}
+		for _, ident := range m.Identifier {
+			if id, ok := epubIdentifier(ident.Data, ident.Scheme); ok {
+				book.Identifiers = append(book.Identifiers, id)
+			}
+		}
+		book.Publisher = firstNonEmpty(m.Publisher)
+		book.Language = firstNonEmpty(m.Language)
+		book.Description = plainText(firstNonEmpty(m.Description))
+		for _, date := range m.Date {
+			// EPUB 2 dates can be for events other than publication, such as modification.
+			if date.Event == "" || strings.Contains(strings.ToLower(date.Event), "publication") {
+				if book.Published = normalizePublished(date.Data); book.Published != "" {
+					break
+				}
+			}
+		}
+		seen := make(map[string]bool)
+		for _, subject := range m.Subject {
+			subject = strings.TrimSpace(subject)
+			if subject != "" && !seen[strings.ToLower(subject)] {
+				book.Subjects = append(book.Subjects, subject)
+				seen[strings.ToLower(subject)] = true
+			}
+		}
 		f.Close()
 
 		return book, true
@@ -112,3 +185,78 @@ func (*EpubMetadataParser) Parse(files []string) (book Book, parsed bool) {
 
 	return
 }
+
+// epubIdentifier converts a dc:identifier to an Identifier.
+// The type comes from the scheme, or from a urn prefix such as urn:isbn: or urn:uuid:.
+// An identifier with neither is only used if it's a valid ISBN.
+func epubIdentifier(data, scheme string) (Identifier, bool) {
+	data = strings.TrimSpace(data)
+	if parts := strings.SplitN(data, ":", 3); len(parts) == 3 && strings.ToLower(parts[0]) == "urn" {
+		if scheme == "" {
+			scheme = parts[1]
+		}
+		if strings.EqualFold(scheme, parts[1]) {
+			data = parts[2]
+		}
+	}
+	if scheme == "" {
+		isbn, err := NormalizeISBN(data)
+		return Identifier{"isbn", isbn}, err == nil
+	}
+	id, err := NormalizeIdentifier(scheme, data)
+	if err != nil {
+		log.Printf("Ignoring identifier: %s", err)
+		return id, false
+	}
+	return id, true
+}
+
+// firstNonEmpty returns the first of values which isn't blank, with surrounding space removed.
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// normalizePublished converts a publication date from metadata to a year, YYYY-MM or YYYY-MM-DD, keeping its precision.
+// It returns an empty string if the date can't be parsed.
+// Calibre writes 0101-01-01 when the date is unknown, so years before 1000 are ignored.
+func normalizePublished(s string) string {
+	s = strings.TrimSpace(s)
+	for _, layout := range []struct{ parse, format string }{
+		{time.RFC3339, "2006-01-02"},
+		{"2006-01-02T15:04:05", "2006-01-02"},
+		{"2006-01-02", "2006-01-02"},
+		{"2006-01", "2006-01"},
+		{"2006", "2006"},
+	} {
+		t, err := time.Parse(layout.parse, s)
+		if err != nil {
+			continue
+		}
+		if t.Year() < 1000 {
+			return ""
+		}
+		return t.Format(layout.format)
+	}
+	return ""
+}
+
+var (
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlBlockRe = regexp.MustCompile(`(?i)</(p|div|li|h[1-6])>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+	blankLineRe = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+// plainText converts a description which may contain HTML, as many EPUB descriptions do, to plain text.
+// Paragraphs are separated by blank lines.
+func plainText(s string) string {
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = htmlBlockRe.ReplaceAllString(s, "\n\n")
+	s = html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+	return strings.TrimSpace(blankLineRe.ReplaceAllString(s, "\n\n"))
+}
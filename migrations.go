@@ -0,0 +1,279 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// A Migration is a single change to the library schema.
+// Migrations are applied in order, and the number of applied migrations is stored in the database's user_version.
+// Once a migration has been released, it must never be changed or reordered; add a new one instead.
+//
+// Up may be nil. If Reindex is set, the search index is rebuilt with the current ftsSchema
+// once all pending migrations have been applied, so migrations never need to know which columns it has.
+type Migration struct {
+	Description string
+	Up          func(tx *sql.Tx) error
+	Reindex     bool
+}
+
+// migrations holds every migration after initialSchema, in the order they must be applied.
+var migrations = []Migration{
+	{Description: "Rebuild the search index, including filenames", Reindex: true},
+	{Description: "Move the search index to FTS5", Reindex: true},
+	{Description: "Add reading status, ratings and notes", Up: createReadingTable},
+	{Description: "Add series index to books", Up: addSeriesIndex},
+	{Description: "Add book identifiers, such as ISBNs", Up: createIdentifiersTable},
+	{Description: "Add publisher, publication date, language, description and subjects", Up: addDescriptiveMetadata, Reindex: true},
+	{Description: "Add book covers", Up: addCover},
+	{Description: "Add author sort names and aliases", Up: addAuthorSortNamesAndAliases},
+	{Description: "Add shelves", Up: createShelvesTables},
+	{Description: "Add saved searches", Up: createSavedSearchesTable},
+	{Description: "Add custom fields", Up: createCustomFieldsTable},
+}
+
+func createReadingTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`create table reading (
+book_id integer primary key references books(id) on delete cascade,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+status text not null default '',
+started_on timestamp,
+finished_on timestamp,
+rating integer check (rating between 1 and 5),
+notes text not null default ''
+)`)
+	return err
+}
+
+func addSeriesIndex(tx *sql.Tx) error {
+	_, err := tx.Exec("alter table books add column series_index real not null default 0")
+	return err
+}
+
+func createIdentifiersTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`create table identifiers (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+type text not null,
+value text not null,
+unique (type, value)
+);
+create index idx_identifiers_book_id on identifiers(book_id);`)
+	return err
+}
+
+func addDescriptiveMetadata(tx *sql.Tx) error {
+	_, err := tx.Exec(`alter table books add column publisher text not null default '';
+alter table books add column published text not null default '';
+alter table books add column language text not null default '';
+alter table books add column description text not null default '';
+create table subjects (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+name text not null,
+unique (book_id, name)
+);`)
+	return err
+}
+
+func addCover(tx *sql.Tx) error {
+	_, err := tx.Exec("alter table books add column cover text not null default ''")
+	return err
+}
+
+func addAuthorSortNamesAndAliases(tx *sql.Tx) error {
+	_, err := tx.Exec(`alter table authors add column sort_name text not null default '';
+create index idx_authors_sort_name on authors(sort_name collate nocase);
+create table author_aliases (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+alias text not null unique collate nocase,
+author_id integer not null references authors(id) on delete cascade
+);`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("select id, name from authors")
+	if err != nil {
+		return err
+	}
+	sortNames := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		sortNames[id] = SortName(name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, sortName := range sortNames {
+		if _, err := tx.Exec("update authors set sort_name=? where id=?", sortName, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the number of migrations that have been applied to the library.
+func (lib *Library) SchemaVersion() (int, error) {
+	return schemaVersion(lib.DB)
+}
+
+// LatestSchemaVersion returns the schema version a library will have once all migrations have been applied.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// PendingMigrations returns the migrations that have not yet been applied to the library, in the order they will be applied.
+func (lib *Library) PendingMigrations() ([]Migration, error) {
+	version, err := lib.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	return pendingMigrations(version)
+}
+
+// Migrate applies all pending migrations to the library in a single transaction.
+// If any migration fails, the library is left at its previous version.
+func (lib *Library) Migrate() error {
+	return migrate(lib.DB)
+}
+
+// Backup copies the library file to a new file next to it, and returns the new file's name.
+// The backup is named after the library and its current schema version, for example books.db.v3.bak.
+func (lib *Library) Backup() (string, error) {
+	version, err := lib.SchemaVersion()
+	if err != nil {
+		return "", errors.Wrap(err, "backup library")
+	}
+	fn := GetUniqueName(lib.filename + ".v" + strconv.Itoa(version) + ".bak")
+	if err := copyFile(lib.filename, fn); err != nil {
+		return "", errors.Wrap(err, "backup library")
+	}
+	return fn, nil
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("pragma user_version").Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "get schema version")
+	}
+	return version, nil
+}
+
+func pendingMigrations(version int) ([]Migration, error) {
+	if version > len(migrations) {
+		return nil, errors.Errorf("library schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+	return migrations[version:], nil
+}
+
+func migrate(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "migrate")
+	}
+	needsReindex := false
+	for i, m := range pending {
+		log.Printf("Applying migration %d: %s", version+i+1, m.Description)
+		if m.Up != nil {
+			if err := m.Up(tx); err != nil {
+				tx.Rollback()
+				return errors.Wrapf(err, "migration %d (%s)", version+i+1, m.Description)
+			}
+		}
+		needsReindex = needsReindex || m.Reindex
+	}
+	if needsReindex {
+		if err := reindex(tx, nil); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "rebuild search index")
+		}
+	}
+	// Pragmas can't take bound parameters.
+	if _, err := tx.Exec("pragma user_version=" + strconv.Itoa(len(migrations))); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "set schema version")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "migrate")
+	}
+	log.Printf("Library migrated from schema version %d to %d", version, len(migrations))
+	return nil
+}
+
+func createShelvesTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`create table shelves (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+name text not null unique collate nocase
+);
+create table books_shelves (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+shelf_id integer not null references shelves(id) on delete cascade,
+book_id integer not null references books(id) on delete cascade,
+position integer not null,
+unique (shelf_id, book_id)
+);
+create index idx_books_shelves_book_id on books_shelves(book_id);`)
+	return err
+}
+
+func createSavedSearchesTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`create table saved_searches (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+name text not null unique collate nocase,
+query text not null
+);`)
+	return err
+}
+
+func createCustomFieldsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`create table custom_fields (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+name text not null,
+value text not null,
+unique (book_id, name)
+);
+create index idx_custom_fields_name_value on custom_fields(name, value);`)
+	return err
+}
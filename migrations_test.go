@@ -0,0 +1,86 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMigrateFromInitialSchema(t *testing.T) {
+	if err := checkFTS5(); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "books-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A library from before migrations existed has the initial schema, with an FTS4 search index, and a user_version of 0.
+	fn := path.Join(dir, "books.db")
+	db, err := sql.Open("sqlite3", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		initialSchema,
+		"insert into books (id, series, title) values (1, 'Sword of Truth', 'Wizards First Rule')",
+		"insert into authors (id, name) values (1, 'Terry Goodkind')",
+		"insert into books_authors (book_id, author_id) values (1, 1)",
+		"insert into files (id, book_id, extension, original_filename, filename, file_size, file_mtime, hash, source) values (1, 1, 'epub', 'wfr.epub', 'Terry Goodkind/Wizards First Rule.epub', 3, datetime(), 'abc', '')",
+		"insert into books_fts (docid, author, series, title, extension, tags, source) values (1, 'Terry Goodkind', 'Sword of Truth', 'Wizards First Rule', 'epub', '', '')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	lib, err := OpenLibrary(fn, path.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Close()
+	if version, err := lib.SchemaVersion(); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %d, %v, want %d", version, err, LatestSchemaVersion())
+	}
+	if _, err := os.Stat(fn + ".v0.bak"); err != nil {
+		t.Errorf("the library wasn't backed up before migrating: %v", err)
+	}
+	var schema string
+	if err := lib.QueryRow("select sql from sqlite_master where name='books_fts'").Scan(&schema); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.ToLower(schema), "fts5") {
+		t.Errorf("the search index wasn't moved to FTS5: %s", schema)
+	}
+	// The filename wasn't in the FTS4 row, so finding it shows the book was reindexed.
+	for _, q := range []string{"goodkind", "filename:wizards", "series:sword"} {
+		if books, _, err := lib.Search(q); err != nil || len(books) != 1 || books[0].ID != 1 {
+			t.Errorf("Search(%q) = %v, %v, want book 1", q, books, err)
+		}
+	}
+	if pending, err := lib.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Errorf("PendingMigrations() = %v, %v, want none", pending, err)
+	}
+}
+
+func TestOpenNewerLibrary(t *testing.T) {
+	lib, dir := newTestLibrary(t)
+	if _, err := lib.Exec("pragma user_version=" + strconv.Itoa(LatestSchemaVersion()+1)); err != nil {
+		t.Fatal(err)
+	}
+	if lib, err := OpenLibrary(path.Join(dir, "books.db"), path.Join(dir, "root")); err == nil {
+		lib.Close()
+		t.Error("opened a library with a newer schema version")
+	}
+}
@@ -0,0 +1,652 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// QueryError is returned when a search query can't be parsed or compiled.
+type QueryError struct {
+	err string
+}
+
+func (qe QueryError) Error() string {
+	return qe.err
+}
+
+func queryErrorf(format string, args ...interface{}) QueryError {
+	return QueryError{errors.Errorf(format, args...).Error()}
+}
+
+// Op is the comparison operator of a query term.
+type Op int
+
+// Comparison operators, written after the colon in field:value.
+const (
+	OpMatch Op = iota // field:value
+	OpEq              // field:=value
+	OpLt              // field:<value
+	OpLe              // field:<=value
+	OpGt              // field:>value
+	OpGe              // field:>=value
+)
+
+var opStrings = map[Op]string{OpMatch: "", OpEq: "=", OpLt: "<", OpLe: "<=", OpGt: ">", OpGe: ">="}
+
+func (op Op) String() string {
+	return opStrings[op]
+}
+
+// A Term is a single condition in a query.
+// Field is empty for text which should be searched for in every field.
+type Term struct {
+	Field   string
+	Op      Op
+	Value   string
+	Phrase  bool // The value was quoted.
+	Prefix  bool // The value ended with *, and matches any word starting with it.
+	Negated bool // The term was preceded by - or NOT, and matches books which don't match it.
+}
+
+// A Clause is a list of terms joined by OR. A book matches a clause if it matches any of its terms.
+type Clause struct {
+	Terms []Term
+}
+
+// SortKey is the order search results are returned in.
+// If Field is empty, results are ordered by relevance.
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// A Query is a parsed search query. A book matches a query if it matches every clause.
+type Query struct {
+	Clauses []Clause
+	Sort    SortKey
+}
+
+// ParseQuery parses a search query.
+//
+// A query is a list of terms separated by spaces, all of which must match.
+// A term is either text to search for in any field, or field:value to search one field.
+// A word ending in a colon, as in Dune: Messiah, is searched for as text.
+// Values can be quoted to search for phrases or include spaces, for example title:"The Shining";
+// in unquoted values, + is treated as a space, so author:Stephen+King also works.
+// A trailing * matches any word starting with the value.
+// Terms can be negated with a leading - or NOT, and joined with OR.
+// Some fields compare values, using field:<value, field:<=value, field:>value, field:>=value, or field:=value.
+// sort:field orders the results by that field, and sort:-field reverses the order.
+//
+// Example: author:"Terry Goodkind" -tag:ocr ext:epub added:>2024-01-01 sort:title
+func ParseQuery(s string) (*Query, error) {
+	q := &Query{}
+	p := queryParser{s: s}
+	joinNext := false
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		word, quoted := p.peekWord()
+		if !quoted {
+			switch word {
+			case "OR":
+				if len(q.Clauses) == 0 || joinNext {
+					return nil, queryErrorf("OR must be between two terms")
+				}
+				p.pos += len(word)
+				joinNext = true
+				continue
+			case "AND":
+				p.pos += len(word)
+				continue
+			}
+		}
+
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if t.Field == "sort" {
+			if t.Negated || joinNext {
+				return nil, queryErrorf("sort can't be negated or joined with OR")
+			}
+			q.Sort = SortKey{Field: strings.TrimPrefix(t.Value, "-"), Descending: strings.HasPrefix(t.Value, "-")}
+			if _, ok := sortKeys[q.Sort.Field]; !ok {
+				return nil, queryErrorf("can't sort by %s", q.Sort.Field)
+			}
+			continue
+		}
+		if joinNext {
+			c := &q.Clauses[len(q.Clauses)-1]
+			c.Terms = append(c.Terms, t)
+			joinNext = false
+		} else {
+			q.Clauses = append(q.Clauses, Clause{[]Term{t}})
+		}
+	}
+	if joinNext {
+		return nil, queryErrorf("OR must be between two terms")
+	}
+	return q, nil
+}
+
+// String returns the query in the form accepted by ParseQuery.
+func (q *Query) String() string {
+	var parts []string
+	for _, c := range q.Clauses {
+		var terms []string
+		for _, t := range c.Terms {
+			terms = append(terms, t.String())
+		}
+		parts = append(parts, strings.Join(terms, " OR "))
+	}
+	if q.Sort.Field != "" {
+		s := "sort:"
+		if q.Sort.Descending {
+			s += "-"
+		}
+		parts = append(parts, s+q.Sort.Field)
+	}
+	return strings.Join(parts, " ")
+}
+
+// String returns the term in the form accepted by ParseQuery.
+// Quotes can't be escaped, so they are removed from quoted values.
+func (t Term) String() string {
+	s := ""
+	if t.Negated {
+		s = "-"
+	}
+	if t.Field != "" {
+		s += t.Field + ":" + t.Op.String()
+	}
+	if t.Phrase || needsQuotes(t.Value) {
+		s += `"` + strings.Replace(t.Value, `"`, "", -1) + `"`
+	} else {
+		s += t.Value
+	}
+	if t.Prefix {
+		s += "*"
+	}
+	return s
+}
+
+// needsQuotes returns true if an unquoted value would be parsed as something else.
+func needsQuotes(value string) bool {
+	switch value {
+	case "", "OR", "AND", "NOT":
+		return true
+	}
+	return strings.IndexFunc(value, unicode.IsSpace) >= 0 || strings.ContainsAny(value, `"+:*`) || strings.HasPrefix(value, "-")
+}
+
+// queryParser holds the state of ParseQuery.
+type queryParser struct {
+	s   string
+	pos int
+}
+
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() {
+		r, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		p.pos += size
+	}
+}
+
+// peekWord returns the text up to the next space without consuming it, and whether it starts with a quote.
+func (p *queryParser) peekWord() (string, bool) {
+	end := strings.IndexFunc(p.s[p.pos:], unicode.IsSpace)
+	if end < 0 {
+		end = len(p.s) - p.pos
+	}
+	word := p.s[p.pos : p.pos+end]
+	return word, strings.HasPrefix(word, `"`)
+}
+
+// term parses a single, possibly negated, term.
+func (p *queryParser) term() (Term, error) {
+	var t Term
+	if word, quoted := p.peekWord(); word == "NOT" && !quoted {
+		p.pos += len(word)
+		p.skipSpace()
+		if p.eof() {
+			return t, queryErrorf("NOT must be followed by a term")
+		}
+		t.Negated = true
+	} else if strings.HasPrefix(word, "-") && len(word) > 1 {
+		p.pos++
+		t.Negated = true
+	}
+
+	// A field name is a word made of letters, digits and underscores, followed by a colon.
+	start := p.pos
+	end := p.pos
+	for end < len(p.s) && (isFieldChar(rune(p.s[end]))) {
+		end++
+	}
+	if end > p.pos && end < len(p.s) && p.s[end] == ':' {
+		t.Field = strings.ToLower(p.s[p.pos:end])
+		p.pos = end + 1
+		for _, op := range []Op{OpLe, OpGe, OpLt, OpGt, OpEq} {
+			if strings.HasPrefix(p.s[p.pos:], op.String()) {
+				t.Op = op
+				p.pos += len(op.String())
+				break
+			}
+		}
+	}
+
+	if !p.eof() && p.s[p.pos] == '"' {
+		// An unterminated quote runs to the end of the query.
+		end := strings.IndexByte(p.s[p.pos+1:], '"')
+		if end < 0 {
+			end = len(p.s) - p.pos - 1
+		}
+		t.Value = p.s[p.pos+1 : p.pos+1+end]
+		t.Phrase = true
+		p.pos += end + 2
+		if p.pos > len(p.s) {
+			p.pos = len(p.s)
+		}
+		if !p.eof() && p.s[p.pos] == '*' {
+			t.Prefix = true
+			p.pos++
+		}
+	} else {
+		word, _ := p.peekWord()
+		p.pos += len(word)
+		if strings.HasSuffix(word, "*") {
+			t.Prefix = true
+			word = strings.TrimSuffix(word, "*")
+		}
+		t.Value = strings.Replace(word, "+", " ", -1)
+	}
+	if strings.TrimSpace(t.Value) == "" && !t.Phrase {
+		if t.Field != "" {
+			// A word followed by a colon and nothing else is part of the text, such as a title.
+			return Term{Value: strings.TrimSpace(p.s[start:p.pos]), Negated: t.Negated}, nil
+		}
+		return t, queryErrorf("empty term")
+	}
+	return t, nil
+}
+
+func isFieldChar(r rune) bool {
+	return r == '_' || (r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+}
+
+// A predicate compiles a term on a field which isn't in the search index into an SQL expression.
+// The expression can refer to the book being matched as b.
+type predicate func(t Term) (string, []interface{}, error)
+
+// predicates maps the fields which aren't in the search index to their predicates.
+var predicates = map[string]predicate{
+	"ext":        extPredicate,
+	"tag":        tagPredicate,
+	"added":      addedPredicate,
+	"size":       sizePredicate,
+	"status":     statusPredicate,
+	"rating":     ratingPredicate,
+	"started":    readingDatePredicate("started_on"),
+	"finished":   readingDatePredicate("finished_on"),
+	"isbn":       identifierTypePredicate("isbn"),
+	"asin":       identifierTypePredicate("asin"),
+	"identifier": identifierPredicate,
+	"language":   languagePredicate,
+	"published":  publishedPredicate,
+	"shelf":      shelfPredicate,
+}
+
+// sortKeys maps the fields results can be sorted by to SQL expressions.
+var sortKeys = map[string]string{
+	"author":    "(select a.sort_name from books_authors ba join authors a on a.id = ba.author_id where ba.book_id = b.id order by ba.id limit 1) collate nocase",
+	"title":     "b.title collate nocase",
+	"series":    "b.series collate nocase, b.series_index",
+	"added":     "b.created_on",
+	"rating":    "(select rating from reading r where r.book_id = b.id)",
+	"finished":  "(select finished_on from reading r where r.book_id = b.id)",
+	"published": "nullif(b.published, '')",
+}
+
+// compiledQuery is the SQL form of a Query.
+type compiledQuery struct {
+	where   string
+	args    []interface{}
+	rank    string // An FTS5 query used to rank and highlight results, or empty if there's nothing to rank by.
+	orderBy string
+}
+
+// compileQuery compiles a query into SQL which can select matching books as b.
+// fields are the custom fields which can be searched, in addition to the built in ones.
+func compileQuery(q *Query, fields []CustomField) (*compiledQuery, error) {
+	cq := &compiledQuery{}
+	var conditions []string
+	var rankTerms []string
+	for _, c := range q.Clauses {
+		var alternatives []string
+		for _, t := range c.Terms {
+			t = unknownFieldAsText(t, fields)
+			expr, args, err := compileTerm(t, fields)
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, expr)
+			cq.args = append(cq.args, args...)
+			if fts, ok := ftsTerm(t); ok && !t.Negated {
+				rankTerms = append(rankTerms, "("+fts+")")
+			}
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " or ")+")")
+	}
+	cq.where = strings.Join(conditions, " and ")
+	if cq.where == "" {
+		cq.where = "1"
+	}
+	cq.rank = strings.Join(rankTerms, " OR ")
+
+	direction := ""
+	if q.Sort.Descending {
+		direction = " desc"
+	}
+	switch {
+	case q.Sort.Field != "":
+		cq.orderBy = sortKeys[q.Sort.Field] + direction + ", b.id"
+	case cq.rank != "":
+		cq.orderBy = "r.rank, b.id"
+	default:
+		cq.orderBy = sortKeys["title"] + ", b.id"
+	}
+	return cq, nil
+}
+
+// unknownFieldAsText returns a term whose field can't be searched, such as foo in foo:bar, as text to search for in every field,
+// since the colon is more likely to be punctuation.
+func unknownFieldAsText(t Term, fields []CustomField) Term {
+	if t.Field == "" || isFTSColumn(t.Field) {
+		return t
+	}
+	if _, ok := predicates[t.Field]; ok {
+		return t
+	}
+	if _, ok := findCustomField(fields, t.Field); ok {
+		return t
+	}
+	return Term{Value: t.Field + ":" + t.Op.String() + t.Value, Phrase: t.Phrase, Prefix: t.Prefix, Negated: t.Negated}
+}
+
+// compileTerm compiles a single term into an SQL expression.
+func compileTerm(t Term, fields []CustomField) (string, []interface{}, error) {
+	var expr string
+	var args []interface{}
+	if fts, ok := ftsTerm(t); ok {
+		if t.Op != OpMatch {
+			return "", nil, queryErrorf("%s can't be compared with %s", t.Field, t.Op)
+		}
+		expr = "b.id in (select rowid from books_fts where books_fts match ?)"
+		args = []interface{}{fts}
+	} else if pred, ok := predicates[t.Field]; ok {
+		var err error
+		expr, args, err = pred(t)
+		if err != nil {
+			return "", nil, err
+		}
+	} else if f, ok := findCustomField(fields, t.Field); ok {
+		var err error
+		expr, args, err = customFieldPredicate(f)(t)
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		return "", nil, queryErrorf("unknown field %s", t.Field)
+	}
+	if t.Negated {
+		expr = "not (" + expr + ")"
+	}
+	return expr, args, nil
+}
+
+// ftsTerm returns the FTS5 query for a term, and false if the term's field isn't in the search index.
+func ftsTerm(t Term) (string, bool) {
+	if t.Field != "" && !isFTSColumn(t.Field) {
+		return "", false
+	}
+	s := `"` + strings.Replace(t.Value, `"`, `""`, -1) + `"`
+	if t.Prefix {
+		s += " *"
+	}
+	if t.Field != "" {
+		s = t.Field + " : " + s
+	}
+	return s, true
+}
+
+func extPredicate(t Term) (string, []interface{}, error) {
+	if t.Op != OpMatch && t.Op != OpEq {
+		return "", nil, queryErrorf("ext can't be compared with %s", t.Op)
+	}
+	return "exists (select 1 from files f where f.book_id = b.id and f.extension = ? collate nocase)",
+		[]interface{}{strings.TrimPrefix(t.Value, ".")}, nil
+}
+
+func tagPredicate(t Term) (string, []interface{}, error) {
+	if t.Op != OpMatch && t.Op != OpEq {
+		return "", nil, queryErrorf("tag can't be compared with %s", t.Op)
+	}
+	return `exists (select 1 from files f join files_tags ft on ft.file_id = f.id join tags t on t.id = ft.tag_id
+	where f.book_id = b.id and t.name = ? collate nocase)`, []interface{}{t.Value}, nil
+}
+
+// addedPredicate compares the date a book was added with a year, month, or day, such as 2018, 2018-09, or 2018-09-28.
+func addedPredicate(t Term) (string, []interface{}, error) {
+	start, end, err := parseDateRange(t.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	return compareRange("b.created_on", t.Op, start, end)
+}
+
+// parseDateRange parses a year, month, or day, and returns the timestamps at its start and just after its end,
+// in the format used by the library.
+func parseDateRange(s string) (string, string, error) {
+	const timestampFormat = "2006-01-02 15:04:05"
+	for _, layout := range []struct {
+		layout       string
+		years, month int
+	}{{"2006-01-02", 0, 0}, {"2006-01", 0, 1}, {"2006", 1, 0}} {
+		start, err := time.Parse(layout.layout, s)
+		if err != nil {
+			continue
+		}
+		end := start.AddDate(layout.years, layout.month, 0)
+		if layout.years == 0 && layout.month == 0 {
+			end = start.AddDate(0, 0, 1)
+		}
+		return start.Format(timestampFormat), end.Format(timestampFormat), nil
+	}
+	return "", "", queryErrorf("invalid date %s; use YYYY, YYYY-MM or YYYY-MM-DD", s)
+}
+
+// compareRange compares column with a range of values, from start up to but not including end.
+// Matching or equal means falling in the range.
+func compareRange(column string, op Op, start, end interface{}) (string, []interface{}, error) {
+	switch op {
+	case OpMatch, OpEq:
+		return column + " >= ? and " + column + " < ?", []interface{}{start, end}, nil
+	case OpLt:
+		return column + " < ?", []interface{}{start}, nil
+	case OpLe:
+		return column + " < ?", []interface{}{end}, nil
+	case OpGt:
+		return column + " >= ?", []interface{}{end}, nil
+	case OpGe:
+		return column + " >= ?", []interface{}{start}, nil
+	}
+	return "", nil, queryErrorf("unknown operator")
+}
+
+// sizePredicate matches books with a file of the given size, such as 500KB or 5MB.
+func sizePredicate(t Term) (string, []interface{}, error) {
+	size, err := parseSize(t.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	return "exists (select 1 from files f where f.book_id = b.id and f.file_size " + sqlOp(t.Op) + " ?)", []interface{}{size}, nil
+}
+
+// statusPredicate matches books with a reading status, such as reading or want-to-read.
+// status:none matches books without one.
+func statusPredicate(t Term) (string, []interface{}, error) {
+	if t.Op != OpMatch && t.Op != OpEq {
+		return "", nil, queryErrorf("status can't be compared with %s", t.Op)
+	}
+	status, err := ParseReadingStatus(t.Value)
+	if err != nil {
+		return "", nil, queryErrorf("%s", err)
+	}
+	if status == StatusNone {
+		return "not exists (select 1 from reading r where r.book_id = b.id and r.status != '')", nil, nil
+	}
+	return "exists (select 1 from reading r where r.book_id = b.id and r.status = ?)", []interface{}{string(status)}, nil
+}
+
+// languagePredicate matches books in a language, such as en. A language without a region also matches its regions,
+// so language:en matches en-US and en-GB.
+func languagePredicate(t Term) (string, []interface{}, error) {
+	if t.Op != OpMatch && t.Op != OpEq {
+		return "", nil, queryErrorf("language can't be compared with %s", t.Op)
+	}
+	return "(b.language = ? collate nocase or b.language like ? escape '\\')",
+		[]interface{}{t.Value, strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(t.Value) + "-%"}, nil
+}
+
+// shelfPredicate matches books on the shelf with the given name, ignoring case.
+func shelfPredicate(t Term) (string, []interface{}, error) {
+	if t.Op != OpMatch && t.Op != OpEq {
+		return "", nil, queryErrorf("shelf can't be compared with %s", t.Op)
+	}
+	return `exists (select 1 from books_shelves bs join shelves s on s.id = bs.shelf_id
+	where bs.book_id = b.id and s.name = ?)`, []interface{}{t.Value}, nil
+}
+
+// publishedPredicate compares the publication date of a book with a year, month, or day, in the same way as addedPredicate.
+// Dates which are only known to the year or month are treated as the start of that year or month.
+// Books without a publication date never match.
+func publishedPredicate(t Term) (string, []interface{}, error) {
+	start, end, err := parseDateRange(t.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	expr, args, err := compareRange("(substr(b.published || '-01-01', 1, 10) || ' 00:00:00')", t.Op, start, end)
+	if err != nil {
+		return "", nil, err
+	}
+	return "b.published != '' and " + expr, args, nil
+}
+
+// identifierTypePredicate returns a predicate which matches books with an identifier of type typ,
+// normalized the same way as stored identifiers, so isbn:0-7653-2635-X matches the ISBN-13 9780765326355.
+func identifierTypePredicate(typ string) predicate {
+	return func(t Term) (string, []interface{}, error) {
+		if t.Op != OpMatch && t.Op != OpEq {
+			return "", nil, queryErrorf("%s can't be compared with %s", t.Field, t.Op)
+		}
+		id, err := NormalizeIdentifier(typ, t.Value)
+		if err != nil {
+			return "", nil, queryErrorf("%s", err)
+		}
+		return "exists (select 1 from identifiers i where i.book_id = b.id and i.type = ? and i.value = ?)", []interface{}{id.Type, id.Value}, nil
+	}
+}
+
+// identifierPredicate matches books with an identifier in the form type:value, such as identifier:uuid:1234,
+// or with a value of any type if no type is given.
+func identifierPredicate(t Term) (string, []interface{}, error) {
+	if t.Op != OpMatch && t.Op != OpEq {
+		return "", nil, queryErrorf("identifier can't be compared with %s", t.Op)
+	}
+	if strings.Contains(t.Value, ":") {
+		id, err := ParseIdentifier(t.Value)
+		if err != nil {
+			return "", nil, queryErrorf("%s", err)
+		}
+		return "exists (select 1 from identifiers i where i.book_id = b.id and i.type = ? and i.value = ?)", []interface{}{id.Type, id.Value}, nil
+	}
+	value := t.Value
+	if isbn, err := NormalizeISBN(value); err == nil {
+		value = isbn
+	}
+	return "exists (select 1 from identifiers i where i.book_id = b.id and i.value = ? collate nocase)", []interface{}{value}, nil
+}
+
+// ratingPredicate compares a book's rating with a number from 1 to 5. Books which haven't been rated never match.
+func ratingPredicate(t Term) (string, []interface{}, error) {
+	rating, err := strconv.Atoi(t.Value)
+	if err != nil || rating < 1 || rating > MaxRating {
+		return "", nil, queryErrorf("invalid rating %s; use a number from 1 to %d", t.Value, MaxRating)
+	}
+	return "exists (select 1 from reading r where r.book_id = b.id and r.rating " + sqlOp(t.Op) + " ?)", []interface{}{rating}, nil
+}
+
+// readingDatePredicate returns a predicate which compares the date a book was started or finished,
+// stored in column of the reading table, in the same way as addedPredicate.
+func readingDatePredicate(column string) predicate {
+	return func(t Term) (string, []interface{}, error) {
+		start, end, err := parseDateRange(t.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		expr, args, err := compareRange("r."+column, t.Op, start, end)
+		if err != nil {
+			return "", nil, err
+		}
+		return "exists (select 1 from reading r where r.book_id = b.id and " + expr + ")", args, nil
+	}
+}
+
+// parseSize parses a size in bytes, with an optional unit of B, KB, MB or GB.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSuffix(upper, u.suffix)
+			multiplier = u.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || n < 0 {
+		return 0, queryErrorf("invalid size %s", s)
+	}
+	return int64(n * multiplier), nil
+}
+
+// sqlOp returns the SQL comparison operator for op. Matching is the same as equality.
+func sqlOp(op Op) string {
+	if op == OpMatch {
+		return "="
+	}
+	return op.String()
+}
@@ -0,0 +1,140 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *Query
+	}{
+		{"shining", &Query{Clauses: []Clause{{[]Term{{Value: "shining"}}}}}},
+		{`title:"The Shining"`, &Query{Clauses: []Clause{{[]Term{{Field: "title", Value: "The Shining", Phrase: true}}}}}},
+		{"author:Stephen+King", &Query{Clauses: []Clause{{[]Term{{Field: "author", Value: "Stephen King"}}}}}},
+		{"Title:shin*", &Query{Clauses: []Clause{{[]Term{{Field: "title", Value: "shin", Prefix: true}}}}}},
+		{"-tag:ocr NOT ext:pdf", &Query{Clauses: []Clause{
+			{[]Term{{Field: "tag", Value: "ocr", Negated: true}}},
+			{[]Term{{Field: "ext", Value: "pdf", Negated: true}}},
+		}}},
+		{"size:<1MB OR ext:txt", &Query{Clauses: []Clause{{[]Term{{Field: "size", Op: OpLt, Value: "1MB"}, {Field: "ext", Value: "txt"}}}}}},
+		{"added:>=2018 AND rating:=5", &Query{Clauses: []Clause{
+			{[]Term{{Field: "added", Op: OpGe, Value: "2018"}}},
+			{[]Term{{Field: "rating", Op: OpEq, Value: "5"}}},
+		}}},
+		{"sort:-added", &Query{Sort: SortKey{Field: "added", Descending: true}}},
+		{"Dune: Messiah", &Query{Clauses: []Clause{{[]Term{{Value: "Dune:"}}}, {[]Term{{Value: "Messiah"}}}}}},
+		{"-dune:", &Query{Clauses: []Clause{{[]Term{{Value: "dune:", Negated: true}}}}}},
+		{"dune  messiah", &Query{Clauses: []Clause{{[]Term{{Value: "dune"}}}, {[]Term{{Value: "messiah"}}}}}},
+		{`"unterminated phrase`, &Query{Clauses: []Clause{{[]Term{{Value: "unterminated phrase", Phrase: true}}}}}},
+	}
+	for _, test := range tests {
+		got, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"OR shining", "shining OR", "NOT", "sort:nothing", "-sort:title", "shining OR sort:title"} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded, want an error", query)
+		} else if _, ok := err.(QueryError); !ok {
+			t.Errorf("ParseQuery(%q) returned %T, want a QueryError", query, err)
+		}
+	}
+}
+
+func TestQueryString(t *testing.T) {
+	for _, query := range []string{`author:"Stephen King" -tag:ocr`, "size:<1MB OR ext:txt sort:-added", "shin*", `"Dune:"`} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", query, err)
+		}
+		if s := q.String(); s != query {
+			t.Errorf("ParseQuery(%q).String() = %q", query, s)
+		}
+	}
+}
+
+func TestUnknownFieldsAsText(t *testing.T) {
+	fields := []CustomField{{Name: "translator", Type: FieldText}}
+	tests := []struct {
+		term Term
+		want Term
+	}{
+		{Term{Field: "foo", Value: "bar"}, Term{Value: "foo:bar"}},
+		{Term{Field: "foo", Op: OpLt, Value: "bar", Negated: true}, Term{Value: "foo:<bar", Negated: true}},
+		{Term{Field: "title", Value: "dune"}, Term{Field: "title", Value: "dune"}},
+		{Term{Field: "tag", Value: "ocr"}, Term{Field: "tag", Value: "ocr"}},
+		{Term{Field: "translator", Value: "roe"}, Term{Field: "translator", Value: "roe"}},
+	}
+	for _, test := range tests {
+		if got := unknownFieldAsText(test.term, fields); got != test.want {
+			t.Errorf("unknownFieldAsText(%+v) = %+v, want %+v", test.term, got, test.want)
+		}
+	}
+	q, err := ParseQuery("foo:bar translator:roe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compileQuery(q, fields); err != nil {
+		t.Errorf("compileQuery: %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"500", 500},
+		{"10B", 10},
+		{"2KB", 2048},
+		{"1.5mb", 3 << 19},
+		{"1G", 1 << 30},
+		{" 3 MB ", 3 << 20},
+	}
+	for _, test := range tests {
+		got, err := parseSize(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", test.s, got, err, test.want)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1KB", "five"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		s, start, end string
+	}{
+		{"2018", "2018-01-01 00:00:00", "2019-01-01 00:00:00"},
+		{"2018-12", "2018-12-01 00:00:00", "2019-01-01 00:00:00"},
+		{"2018-02-28", "2018-02-28 00:00:00", "2018-03-01 00:00:00"},
+	}
+	for _, test := range tests {
+		start, end, err := parseDateRange(test.s)
+		if err != nil || start != test.start || end != test.end {
+			t.Errorf("parseDateRange(%q) = %s, %s, %v, want %s, %s", test.s, start, end, err, test.start, test.end)
+		}
+	}
+	for _, s := range []string{"18", "2018-13", "2018-02-30", "yesterday"} {
+		if _, _, err := parseDateRange(s); err == nil {
+			t.Errorf("parseDateRange(%q) succeeded, want an error", s)
+		}
+	}
+}
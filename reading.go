@@ -0,0 +1,195 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A ReadingStatus is where someone is in reading a book.
+type ReadingStatus string
+
+// The reading statuses a book can have. A book which hasn't been given a status has StatusNone.
+const (
+	StatusNone       ReadingStatus = ""
+	StatusWantToRead ReadingStatus = "want-to-read"
+	StatusReading    ReadingStatus = "reading"
+	StatusFinished   ReadingStatus = "finished"
+	StatusAbandoned  ReadingStatus = "abandoned"
+)
+
+// ReadingStatuses holds every status except StatusNone, in the order they usually happen.
+var ReadingStatuses = []ReadingStatus{StatusWantToRead, StatusReading, StatusFinished, StatusAbandoned}
+
+// Description returns the status in words, such as "want to read".
+func (s ReadingStatus) Description() string {
+	if s == StatusNone {
+		return "none"
+	}
+	return strings.Replace(string(s), "-", " ", -1)
+}
+
+// ParseReadingStatus parses a reading status, ignoring case.
+// Words may be separated by spaces, hyphens or underscores, so "want to read" is the same as want-to-read.
+// An empty string or "none" is StatusNone.
+func ParseReadingStatus(s string) (ReadingStatus, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer(" ", "-", "_", "-").Replace(s)
+	if s == "" || s == "none" {
+		return StatusNone, nil
+	}
+	for _, status := range ReadingStatuses {
+		if s == string(status) {
+			return status, nil
+		}
+	}
+	return StatusNone, errors.Errorf("unknown reading status %s; use want-to-read, reading, finished, abandoned or none", s)
+}
+
+// MaxRating is the highest rating a book can be given. Ratings start from 1.
+const MaxRating = 5
+
+// ParseRating parses a rating from 1 to MaxRating. An empty string or "none" returns 0, meaning not rated.
+func ParseRating(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ToLower(s) == "none" {
+		return 0, nil
+	}
+	rating, err := strconv.Atoi(s)
+	if err != nil || rating < 1 || rating > MaxRating {
+		return 0, errors.Errorf("invalid rating %s; use a number from 1 to %d, or none", s, MaxRating)
+	}
+	return rating, nil
+}
+
+// Reading holds what someone thinks of a book: whether they've read it, when, a rating and notes.
+type Reading struct {
+	Status   ReadingStatus `json:"status"`
+	Started  *time.Time    `json:"started,omitempty"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	// Rating is from 1 to MaxRating, or 0 if the book hasn't been rated.
+	Rating int    `json:"rating,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+}
+
+// IsZero returns true if nothing has been recorded about reading a book.
+func (r Reading) IsZero() bool {
+	return r.Status == StatusNone && r.Started == nil && r.Finished == nil && r.Rating == 0 && r.Notes == ""
+}
+
+// ParseReadingDate parses a date in the form YYYY-MM-DD, for when a book was started or finished.
+// An empty string or "none" returns nil.
+func ParseReadingDate(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ToLower(s) == "none" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, errors.Errorf("invalid date %s; use YYYY-MM-DD", s)
+	}
+	return &t, nil
+}
+
+// SetReading replaces the reading status, dates, rating and notes of a book.
+// If r is zero, they're removed.
+func (lib *Library) SetReading(bookID int64, r Reading) error {
+	if r.Rating < 0 || r.Rating > MaxRating {
+		return errors.Errorf("rating must be from 1 to %d", MaxRating)
+	}
+	status, err := ParseReadingStatus(string(r.Status))
+	if err != nil {
+		return err
+	}
+	r.Status = status
+
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "set reading")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("select exists (select 1 from books where id=?)", bookID).Scan(&exists); err != nil {
+		return errors.Wrap(err, "set reading")
+	}
+	if !exists {
+		return errors.Errorf("book %d not found", bookID)
+	}
+
+	if r.IsZero() {
+		if _, err := tx.Exec("delete from reading where book_id=?", bookID); err != nil {
+			return errors.Wrap(err, "set reading")
+		}
+		return errors.Wrap(tx.Commit(), "set reading")
+	}
+
+	res, err := tx.Exec("update reading set updated_on=datetime(), status=?, started_on=?, finished_on=?, rating=nullif(?, 0), notes=? where book_id=?",
+		string(r.Status), readingDate(r.Started), readingDate(r.Finished), r.Rating, r.Notes, bookID)
+	if err != nil {
+		return errors.Wrap(err, "set reading")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "set reading")
+	} else if n == 0 {
+		_, err := tx.Exec(`insert into reading (book_id, status, started_on, finished_on, rating, notes)
+		values (?, ?, ?, ?, nullif(?, 0), ?)`,
+			bookID, string(r.Status), readingDate(r.Started), readingDate(r.Finished), r.Rating, r.Notes)
+		if err != nil {
+			return errors.Wrap(err, "set reading")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "set reading")
+}
+
+// readingDate formats a date for the reading table, in the same format as the library's other timestamps.
+func readingDate(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// getReadingByBookIDs gets the reading information for each book ID which has any.
+func getReadingByBookIDs(tx *sql.Tx, ids []int64) (map[int64]Reading, error) {
+	m := make(map[int64]Reading)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select book_id, status, started_on, finished_on, coalesce(rating, 0), notes from reading where book_id in (" + joinInt64s(ids, ",") + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var r Reading
+		var status string
+		if err := rows.Scan(&id, &status, &r.Started, &r.Finished, &r.Rating, &r.Notes); err != nil {
+			return nil, err
+		}
+		r.Status = ReadingStatus(status)
+		m[id] = r
+	}
+	return m, rows.Err()
+}
+
+// mergeReading keeps the reading information of the first book when merging books.
+// If it has none, the reading information of the other book with the lowest ID is used instead.
+func mergeReading(tx *sql.Tx, ids []int64) error {
+	_, err := tx.Exec(`update reading set book_id=? where book_id = (select book_id from reading where book_id in (`+joinInt64s(ids[1:], ",")+`) order by book_id limit 1)
+	and not exists (select 1 from reading where book_id=?)`, ids[0], ids[0])
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("delete from reading where book_id in (" + joinInt64s(ids[1:], ",") + ")")
+	return err
+}
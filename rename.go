@@ -0,0 +1,172 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// A RenamedFile is a file whose name was, or would be, changed by RenameFiles.
+// Filenames are relative to the books root.
+type RenamedFile struct {
+	ID      int64
+	OldName string
+	NewName string
+}
+
+// GetAllBookIDs returns the IDs of every book in the library.
+func (lib *Library) GetAllBookIDs() ([]int64, error) {
+	rows, err := lib.Query("select id from books order by id")
+	if err != nil {
+		return nil, errors.Wrap(err, "get book IDs")
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var id int64
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "get book IDs")
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RenameFiles renames the files of the books with the given IDs to match tmpl, or each file's template override if it has one.
+// It returns the files whose names changed.
+// Files are moved on disk and their names are updated in the library and the search index in the same transaction;
+// if any file can't be renamed, the files which were already moved are moved back.
+// Files which are missing from the books root are skipped.
+// If dryRun is true, nothing is changed.
+func (lib *Library) RenameFiles(ids []int64, tmpl *template.Template, dryRun bool) ([]RenamedFile, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "get transaction")
+	}
+	books, err := getBooksByID(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "get books")
+	}
+
+	renamed := []RenamedFile{}
+	taken := make(map[string]bool)
+	undo := func() {
+		if dryRun {
+			return
+		}
+		for i := len(renamed) - 1; i >= 0; i-- {
+			r := renamed[i]
+			if err := moveFile(path.Join(lib.booksRoot, r.NewName), path.Join(lib.booksRoot, r.OldName)); err != nil {
+				log.Printf("Cannot move %s back to %s: %s", r.NewName, r.OldName, err)
+			}
+		}
+	}
+
+	for i := range books {
+		book := &books[i]
+		n := len(renamed)
+		for _, bf := range book.Files {
+			oldPath := path.Join(lib.booksRoot, bf.CurrentFilename)
+			if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+				log.Printf("File %d is missing, not renaming: %s", bf.ID, oldPath)
+				continue
+			}
+			newName, err := lib.newFilename(book, bf, tmpl, taken)
+			if err != nil {
+				undo()
+				tx.Rollback()
+				return nil, errors.Wrapf(err, "get new name for file %d", bf.ID)
+			}
+			if newName == bf.CurrentFilename {
+				continue
+			}
+			taken[newName] = true
+
+			if !dryRun {
+				if _, err := tx.Exec("update files set updated_on=datetime(), filename=? where id=?", newName, bf.ID); err != nil {
+					undo()
+					tx.Rollback()
+					return nil, errors.Wrapf(err, "update filename for file %d", bf.ID)
+				}
+				newPath := path.Join(lib.booksRoot, newName)
+				if err := os.MkdirAll(path.Dir(newPath), 0755); err != nil {
+					undo()
+					tx.Rollback()
+					return nil, errors.Wrapf(err, "rename file %d", bf.ID)
+				}
+				if err := moveFile(oldPath, newPath); err != nil {
+					undo()
+					tx.Rollback()
+					return nil, errors.Wrapf(err, "rename file %d", bf.ID)
+				}
+			}
+			renamed = append(renamed, RenamedFile{bf.ID, bf.CurrentFilename, newName})
+		}
+		// The search index holds the names of a book's files.
+		if !dryRun && len(renamed) > n {
+			if err := reindexBookInSearch(tx, book.ID); err != nil {
+				undo()
+				tx.Rollback()
+				return nil, errors.Wrapf(err, "reindex book %d", book.ID)
+			}
+		}
+	}
+
+	if dryRun {
+		tx.Rollback()
+		return renamed, nil
+	}
+	if err := tx.Commit(); err != nil {
+		undo()
+		return nil, errors.Wrap(err, "rename files")
+	}
+	for _, r := range renamed {
+		lib.removeEmptyDirs(path.Dir(path.Join(lib.booksRoot, r.OldName)))
+	}
+	return renamed, nil
+}
+
+// newFilename returns the name a file should have according to tmpl or its template override, relative to the books root.
+// If another file already has that name, or it's in taken, a number is added to make it unique, as GetUniqueName does.
+func (lib *Library) newFilename(book *Book, bf BookFile, tmpl *template.Template, taken map[string]bool) (string, error) {
+	t := tmpl
+	if bf.TemplateOverride != "" {
+		var err error
+		// Cloning keeps the functions available to the configured template.
+		if t, err = tmpl.Clone(); err != nil {
+			return "", err
+		}
+		if t, err = t.Parse(bf.TemplateOverride); err != nil {
+			return "", errors.Wrap(err, "parse template override")
+		}
+	}
+	s, err := bf.Filename(t, book)
+	if err != nil {
+		return "", err
+	}
+	s = strings.Replace(TruncateFilename(s), string(filepath.Separator), "/", -1)
+
+	ext := path.Ext(s)
+	newName := s
+	for i := 1; ; i++ {
+		if newName == bf.CurrentFilename {
+			return newName, nil
+		}
+		if _, err := os.Stat(path.Join(lib.booksRoot, newName)); os.IsNotExist(err) && !taken[newName] {
+			return newName, nil
+		}
+		newName = strings.TrimSuffix(s, ext) + " (" + strconv.Itoa(i) + ")" + ext
+	}
+}
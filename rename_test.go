@@ -0,0 +1,52 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"os"
+	"path"
+	"testing"
+	"text/template"
+)
+
+func TestRenameFiles(t *testing.T) {
+	lib, dir := newTestLibrary(t)
+	id := importTestBook(t, lib, dir, "Terry Goodkind", "Wizards First Rule", "txt", "wizards")
+	if _, err := lib.Exec("update books set series='Sword of Truth' where id=?", id); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.Must(template.New("output").Parse("{{index .Authors 0}}/{{.Series}}/{{.Title}}.{{.Extension}}"))
+	const newName = "Terry Goodkind/Sword of Truth/Wizards First Rule.txt"
+
+	renamed, err := lib.RenameFiles([]int64{id}, tmpl, true)
+	if err != nil || len(renamed) != 1 || renamed[0].NewName != newName {
+		t.Fatalf("dry run: RenameFiles = %v, %v, want %s", renamed, err, newName)
+	}
+	if _, err := os.Stat(path.Join(dir, "root", newName)); !os.IsNotExist(err) {
+		t.Fatal("a dry run renamed the file")
+	}
+
+	renamed, err = lib.RenameFiles([]int64{id}, tmpl, false)
+	if err != nil || len(renamed) != 1 || renamed[0].NewName != newName {
+		t.Fatalf("RenameFiles = %v, %v, want %s", renamed, err, newName)
+	}
+	if _, err := os.Stat(path.Join(dir, "root", newName)); err != nil {
+		t.Errorf("file wasn't moved: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, "root", renamed[0].OldName)); !os.IsNotExist(err) {
+		t.Errorf("old file is still there: %v", err)
+	}
+	// The search index must have the new filename, not the old one.
+	if books, _, err := lib.Search(`filename:"Sword of Truth"`); err != nil || len(books) != 1 || books[0].ID != id {
+		t.Errorf("searching for the new filename found %v, %v, want book %d", books, err, id)
+	}
+	if n := countRows(t, lib, "select count(*) from books_fts where filename like '%Goodkind/Wizards%'"); n != 0 {
+		t.Error("the search index still has the old filename")
+	}
+
+	if renamed, err := lib.RenameFiles([]int64{id}, tmpl, false); err != nil || len(renamed) != 0 {
+		t.Errorf("renaming again = %v, %v, want nothing renamed", renamed, err)
+	}
+}
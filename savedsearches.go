@@ -0,0 +1,114 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A SavedSearch is a named search query, such as new epubs by favorite authors.
+// Its books aren't stored; they're found again each time it's run, so it acts as a collection which keeps itself up to date.
+type SavedSearch struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// SaveSearch saves a search query under a name, replacing the query of any saved search with that name.
+// Names are matched ignoring case. If the query is invalid, the returned error is a QueryError.
+func (lib *Library) SaveSearch(name, query string) error {
+	name = strings.TrimSpace(name)
+	query = strings.TrimSpace(query)
+	if name == "" {
+		return errors.New("the saved search name must not be empty")
+	}
+	if query == "" {
+		return errors.New("the query must not be empty")
+	}
+	if _, err := ParseQuery(query); err != nil {
+		return err
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "save search")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("update saved_searches set updated_on=datetime(), query=? where name=?", query, name)
+	if err != nil {
+		return errors.Wrap(err, "save search")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "save search")
+	} else if n == 0 {
+		if _, err := tx.Exec("insert into saved_searches (name, query) values (?, ?)", name, query); err != nil {
+			return errors.Wrap(err, "save search")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "save search")
+}
+
+// DeleteSavedSearch deletes a saved search.
+func (lib *Library) DeleteSavedSearch(name string) error {
+	res, err := lib.Exec("delete from saved_searches where name=?", strings.TrimSpace(name))
+	if err != nil {
+		return errors.Wrap(err, "delete saved search")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "delete saved search")
+	} else if n == 0 {
+		return errors.Errorf("saved search %s not found", name)
+	}
+	return nil
+}
+
+// GetSavedSearches returns every saved search, ordered by name.
+func (lib *Library) GetSavedSearches() ([]SavedSearch, error) {
+	rows, err := lib.Query("select id, name, query from saved_searches order by name collate nocase")
+	if err != nil {
+		return nil, errors.Wrap(err, "get saved searches")
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		var s SavedSearch
+		if err := rows.Scan(&s.ID, &s.Name, &s.Query); err != nil {
+			return nil, errors.Wrap(err, "get saved searches")
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+// GetSavedSearch returns the saved search with the given name, matched ignoring case.
+// It returns false if there is no saved search with that name.
+func (lib *Library) GetSavedSearch(name string) (SavedSearch, bool, error) {
+	var s SavedSearch
+	err := lib.QueryRow("select id, name, query from saved_searches where name=?", strings.TrimSpace(name)).Scan(&s.ID, &s.Name, &s.Query)
+	if err == sql.ErrNoRows {
+		return s, false, nil
+	} else if err != nil {
+		return s, false, errors.Wrap(err, "get saved search")
+	}
+	return s, true, nil
+}
+
+// SearchSaved runs a saved search, returning a page of its books as described in SearchPaged.
+// Since a saved search is a collection, misspellings in its query aren't corrected: if nothing matches, no books are returned.
+func (lib *Library) SearchSaved(s SavedSearch, offset, limit, moreResultsLimit int) ([]Book, int, error) {
+	q, err := ParseQuery(s.Query)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, snippets, err := lib.searchIDs(q, offset, limit, moreResultsLimit)
+	if err != nil {
+		return nil, 0, err
+	}
+	return lib.searchResults(ids, snippets, limit)
+}
@@ -0,0 +1,260 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Shelf is a named collection of books, such as a reading list, kept in the order they were arranged in.
+// A book can be on any number of shelves.
+type Shelf struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Books []Book `json:"books"`
+}
+
+// getShelfID returns the ID and name of a shelf. Shelf names are matched ignoring case.
+func getShelfID(tx *sql.Tx, name string) (int64, string, bool, error) {
+	var id int64
+	err := tx.QueryRow("select id, name from shelves where name=?", strings.TrimSpace(name)).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	} else if err != nil {
+		return 0, "", false, err
+	}
+	return id, name, true, nil
+}
+
+// mustGetShelfID is like getShelfID, but returns an error if the shelf doesn't exist.
+func mustGetShelfID(tx *sql.Tx, name string) (int64, error) {
+	id, _, found, err := getShelfID(tx, name)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, errors.Errorf("shelf %s not found", name)
+	}
+	return id, nil
+}
+
+// CreateShelf adds an empty shelf.
+func (lib *Library) CreateShelf(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("the shelf name must not be empty")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "create shelf")
+	}
+	defer tx.Rollback()
+
+	if _, _, found, err := getShelfID(tx, name); err != nil {
+		return errors.Wrap(err, "create shelf")
+	} else if found {
+		return errors.Errorf("shelf %s already exists", name)
+	}
+	if _, err := tx.Exec("insert into shelves (name) values (?)", name); err != nil {
+		return errors.Wrap(err, "create shelf")
+	}
+	return errors.Wrap(tx.Commit(), "create shelf")
+}
+
+// DeleteShelf deletes a shelf. The books on it are kept in the library.
+func (lib *Library) DeleteShelf(name string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "delete shelf")
+	}
+	defer tx.Rollback()
+
+	id, err := mustGetShelfID(tx, name)
+	if err != nil {
+		return errors.Wrap(err, "delete shelf")
+	}
+	if _, err := tx.Exec("delete from books_shelves where shelf_id=?", id); err != nil {
+		return errors.Wrap(err, "delete shelf")
+	}
+	if _, err := tx.Exec("delete from shelves where id=?", id); err != nil {
+		return errors.Wrap(err, "delete shelf")
+	}
+	return errors.Wrap(tx.Commit(), "delete shelf")
+}
+
+// AddToShelf puts books on a shelf, in the given order, starting at position, which counts from 1.
+// If position is 0 or past the end of the shelf, the books are added to the end.
+// Books which are already on the shelf are moved.
+func (lib *Library) AddToShelf(name string, bookIDs []int64, position int) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "add to shelf")
+	}
+	defer tx.Rollback()
+
+	shelfID, err := mustGetShelfID(tx, name)
+	if err != nil {
+		return errors.Wrap(err, "add to shelf")
+	}
+	adding := make(map[int64]bool)
+	var unique []int64
+	for _, id := range bookIDs {
+		if adding[id] {
+			continue
+		}
+		var exists bool
+		if err := tx.QueryRow("select exists (select 1 from books where id=?)", id).Scan(&exists); err != nil {
+			return errors.Wrap(err, "add to shelf")
+		}
+		if !exists {
+			return errors.Errorf("book %d not found", id)
+		}
+		adding[id] = true
+		unique = append(unique, id)
+	}
+	current, err := getShelfBookIDs(tx, shelfID)
+	if err != nil {
+		return errors.Wrap(err, "add to shelf")
+	}
+	var kept []int64
+	for _, id := range current {
+		if !adding[id] {
+			kept = append(kept, id)
+		}
+	}
+	if position <= 0 || position > len(kept) {
+		position = len(kept) + 1
+	}
+	order := append(append(append([]int64{}, kept[:position-1]...), unique...), kept[position-1:]...)
+	for _, id := range unique {
+		if _, err := tx.Exec("insert or ignore into books_shelves (shelf_id, book_id, position) values (?, ?, 0)", shelfID, id); err != nil {
+			return errors.Wrap(err, "add to shelf")
+		}
+	}
+	if err := setShelfOrder(tx, shelfID, order); err != nil {
+		return errors.Wrap(err, "add to shelf")
+	}
+	return errors.Wrap(tx.Commit(), "add to shelf")
+}
+
+// RemoveFromShelf takes books off a shelf. The books are kept in the library.
+func (lib *Library) RemoveFromShelf(name string, bookIDs []int64) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "remove from shelf")
+	}
+	defer tx.Rollback()
+
+	shelfID, err := mustGetShelfID(tx, name)
+	if err != nil {
+		return errors.Wrap(err, "remove from shelf")
+	}
+	for _, id := range bookIDs {
+		res, err := tx.Exec("delete from books_shelves where shelf_id=? and book_id=?", shelfID, id)
+		if err != nil {
+			return errors.Wrap(err, "remove from shelf")
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return errors.Wrap(err, "remove from shelf")
+		} else if n == 0 {
+			return errors.Errorf("book %d isn't on shelf %s", id, name)
+		}
+	}
+	order, err := getShelfBookIDs(tx, shelfID)
+	if err != nil {
+		return errors.Wrap(err, "remove from shelf")
+	}
+	if err := setShelfOrder(tx, shelfID, order); err != nil {
+		return errors.Wrap(err, "remove from shelf")
+	}
+	return errors.Wrap(tx.Commit(), "remove from shelf")
+}
+
+// getShelfBookIDs returns the IDs of the books on a shelf, in order.
+func getShelfBookIDs(tx *sql.Tx, shelfID int64) ([]int64, error) {
+	return queryIDs(tx, "select book_id from books_shelves where shelf_id=? order by position, id", shelfID)
+}
+
+// setShelfOrder numbers the books on a shelf from 1, in the order of bookIDs.
+func setShelfOrder(tx *sql.Tx, shelfID int64, bookIDs []int64) error {
+	for i, id := range bookIDs {
+		_, err := tx.Exec("update books_shelves set updated_on=datetime(), position=? where shelf_id=? and book_id=? and position!=?", i+1, shelfID, id, i+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetShelves returns every shelf, with the number of books on it, ordered by name.
+func (lib *Library) GetShelves() ([]Category, error) {
+	return lib.getCategories(`select s.id, s.name, count(bs.book_id) from shelves s
+	left join books_shelves bs on bs.shelf_id = s.id
+	group by s.id order by s.name collate nocase`)
+}
+
+// GetShelf returns a shelf and its books, in order. Shelf names are matched ignoring case.
+// It returns false if there is no shelf with that name.
+func (lib *Library) GetShelf(name string) (Shelf, bool, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return Shelf{}, false, errors.Wrap(err, "get shelf")
+	}
+	defer tx.Rollback()
+
+	id, name, found, err := getShelfID(tx, name)
+	if err != nil || !found {
+		return Shelf{}, false, errors.Wrap(err, "get shelf")
+	}
+	ids, err := getShelfBookIDs(tx, id)
+	if err != nil {
+		return Shelf{}, false, errors.Wrap(err, "get shelf")
+	}
+	books, err := getBooksByID(tx, ids)
+	if err != nil {
+		return Shelf{}, false, errors.Wrap(err, "get books")
+	}
+	books = sortBooksByID(books, ids)
+	if books == nil {
+		books = []Book{}
+	}
+	return Shelf{ID: id, Name: name, Books: books}, true, nil
+}
+
+// getShelvesByBookIDs gets the names of the shelves each book is on, ordered by name.
+func getShelvesByBookIDs(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
+	m := make(map[int64][]string)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select bs.book_id, s.name from books_shelves bs join shelves s on s.id = bs.shelf_id where bs.book_id in (" + joinInt64s(ids, ",") + ") order by s.name collate nocase")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var name string
+		if err := rows.Scan(&bookID, &name); err != nil {
+			return nil, err
+		}
+		m[bookID] = append(m[bookID], name)
+	}
+	return m, rows.Err()
+}
+
+// mergeShelves moves the other books' places on shelves to the first book, unless it is already on the same shelf.
+func mergeShelves(tx *sql.Tx, ids []int64) error {
+	others := joinInt64s(ids[1:], ",")
+	if _, err := tx.Exec("update or ignore books_shelves set updated_on=datetime(), book_id=? where book_id in ("+others+")", ids[0]); err != nil {
+		return err
+	}
+	_, err := tx.Exec("delete from books_shelves where book_id in (" + others + ")")
+	return err
+}
@@ -0,0 +1,189 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// getTagID returns the ID of a tag. Tags are matched ignoring case, as in searches,
+// but a tag with exactly the given name is preferred.
+func getTagID(tx *sql.Tx, name string) (int64, bool, error) {
+	var id int64
+	err := tx.QueryRow("select id from tags where name=? collate nocase order by name != ? limit 1", name, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// getTagBookIDs returns the IDs of the books with a file that has the given tag, in order.
+func getTagBookIDs(tx *sql.Tx, tagID int64) ([]int64, error) {
+	return queryIDs(tx, "select distinct f.book_id from files f join files_tags ft on ft.file_id = f.id where ft.tag_id=? order by f.book_id", tagID)
+}
+
+// RenameTag renames a tag on every file which has it, and returns the IDs of the books with those files,
+// whose file names may need to change.
+// It fails if another tag already has the new name; use MergeTags to combine them.
+func (lib *Library) RenameTag(oldName, newName string) ([]int64, error) {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return nil, errors.New("the new name must not be empty")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "rename tag")
+	}
+	defer tx.Rollback()
+
+	id, found, err := getTagID(tx, oldName)
+	if err != nil {
+		return nil, errors.Wrap(err, "rename tag")
+	}
+	if !found {
+		return nil, errors.Errorf("tag %s not found", oldName)
+	}
+	var existingID int64
+	err = tx.QueryRow("select id from tags where name=?", newName).Scan(&existingID)
+	if err == nil && existingID != id {
+		return nil, errors.Errorf("tag %s already exists", newName)
+	} else if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "rename tag")
+	}
+	if _, err := tx.Exec("update tags set updated_on=datetime(), name=? where id=?", newName, id); err != nil {
+		return nil, errors.Wrap(err, "rename tag")
+	}
+	bookIDs, err := getTagBookIDs(tx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "rename tag")
+	}
+	for _, bookID := range bookIDs {
+		if err := reindexBookInSearch(tx, bookID); err != nil {
+			return nil, errors.Wrap(err, "index book in search")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "rename tag")
+	}
+	return bookIDs, nil
+}
+
+// MergeTags replaces the given tags with the tag into on every file which has them, and removes them.
+// The tag into is added if it doesn't exist yet.
+// It returns the IDs of the books whose files were changed, in order.
+func (lib *Library) MergeTags(tags []string, into string) ([]int64, error) {
+	into = strings.TrimSpace(into)
+	if into == "" {
+		return nil, errors.New("the tag to merge into must not be empty")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "merge tags")
+	}
+	defer tx.Rollback()
+
+	var intoID int64
+	err = tx.QueryRow("select id from tags where name=?", into).Scan(&intoID)
+	if err == sql.ErrNoRows {
+		res, err := tx.Exec("insert into tags (name) values (?)", into)
+		if err != nil {
+			return nil, errors.Wrap(err, "merge tags")
+		}
+		if intoID, err = res.LastInsertId(); err != nil {
+			return nil, errors.Wrap(err, "merge tags")
+		}
+	} else if err != nil {
+		return nil, errors.Wrap(err, "merge tags")
+	}
+
+	changed := make(map[int64]bool)
+	for _, tag := range tags {
+		id, found, err := getTagID(tx, tag)
+		if err != nil {
+			return nil, errors.Wrap(err, "merge tags")
+		}
+		if !found {
+			return nil, errors.Errorf("tag %s not found", tag)
+		}
+		if id == intoID {
+			continue
+		}
+		bookIDs, err := getTagBookIDs(tx, id)
+		if err != nil {
+			return nil, errors.Wrap(err, "merge tags")
+		}
+		for _, bookID := range bookIDs {
+			changed[bookID] = true
+		}
+		// Files which already have both tags keep their existing link to the new one.
+		if _, err := tx.Exec("update or ignore files_tags set updated_on=datetime(), tag_id=? where tag_id=?", intoID, id); err != nil {
+			return nil, errors.Wrapf(err, "merge tag %s", tag)
+		}
+		if _, err := tx.Exec("delete from files_tags where tag_id=?", id); err != nil {
+			return nil, errors.Wrapf(err, "merge tag %s", tag)
+		}
+		if _, err := tx.Exec("delete from tags where id=?", id); err != nil {
+			return nil, errors.Wrapf(err, "merge tag %s", tag)
+		}
+	}
+	var bookIDs []int64
+	for bookID := range changed {
+		if err := reindexBookInSearch(tx, bookID); err != nil {
+			return nil, errors.Wrap(err, "index book in search")
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+	sort.Slice(bookIDs, func(i, j int) bool { return bookIDs[i] < bookIDs[j] })
+	// If nothing was merged into a new tag, it isn't kept.
+	if err := deleteOrphans(tx); err != nil {
+		return nil, errors.Wrap(err, "delete orphans")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "merge tags")
+	}
+	return bookIDs, nil
+}
+
+// RemoveTag removes a tag from every file which has it, and returns the IDs of the books with those files.
+func (lib *Library) RemoveTag(name string) ([]int64, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "remove tag")
+	}
+	defer tx.Rollback()
+
+	id, found, err := getTagID(tx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "remove tag")
+	}
+	if !found {
+		return nil, errors.Errorf("tag %s not found", name)
+	}
+	bookIDs, err := getTagBookIDs(tx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "remove tag")
+	}
+	if _, err := tx.Exec("delete from files_tags where tag_id=?", id); err != nil {
+		return nil, errors.Wrap(err, "remove tag")
+	}
+	if _, err := tx.Exec("delete from tags where id=?", id); err != nil {
+		return nil, errors.Wrap(err, "remove tag")
+	}
+	for _, bookID := range bookIDs {
+		if err := reindexBookInSearch(tx, bookID); err != nil {
+			return nil, errors.Wrap(err, "index book in search")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "remove tag")
+	}
+	return bookIDs, nil
+}